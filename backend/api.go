@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -24,12 +25,14 @@ func RestRoot(router *mux.Router, database Database, notification Notification,
 // HTTP multiplexer for the API.
 func RestApi(router *mux.Router, database Database, notification Notification, scheduler Scheduler) {
 	RestUserAPI(AddHandler(router, "/user"), database, notification)
-	RestGroupAPI(AddHandler(router, "/group"), database, notification)
+	RestGroupAPI(AddHandler(router, "/group"), database, notification, scheduler)
 	RestPushAPI(AddHandler(router, "/push"), database, notification)
+	RestSessionAPI(AddHandler(router, "/session"), database)
 
 	// temporary API for testing the scheduler.
 	router.HandleFunc("/schedule", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(fmt.Sprintf("%v", scheduler.Schedule(time.Now().Add(time.Minute), Activation{}))))
+		jobID, err := scheduler.Schedule(time.Now().Add(time.Minute), Activation{})
+		w.Write([]byte(fmt.Sprintf("%v %v", jobID, err)))
 	})
 }
 
@@ -97,7 +100,56 @@ func WebSocket(database Database, connectionID ConnectionID, userID *UserID) err
 }
 
 // Scheduled activation event handler.
-func Activate(activation Activation) error {
-	log.Println("running cron job")
-	return nil
+//
+// Activations may be delivered more than once, so handling must be idempotent.
+func Activate(activation Activation, database Database, notification Notification) error {
+	return activateAt(activation, time.Now(), database, notification)
+}
+
+// Handles an activation as though the current time were `now`.
+func activateAt(activation Activation, now time.Time, database Database, notification Notification) error {
+	log.Printf("activation userID=%v groupID=%v\n", activation.UserID, activation.GroupID)
+	var groupIDs []GroupID
+	if activation.GroupID != nil {
+		groupIDs = append(groupIDs, *activation.GroupID)
+	} else if activation.UserID != nil {
+		user, err := database.ReadUser(*activation.UserID)
+		if err != nil {
+			return err
+		}
+		if user != nil {
+			groupIDs = append(groupIDs, user.Groups...)
+		}
+	} else {
+		var err error
+		if groupIDs, err = database.ReadGroupIDs(); err != nil {
+			return err
+		}
+	}
+	var errs []error
+	for _, groupID := range groupIDs {
+		group, err := database.ReadGroup(groupID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if group == nil {
+			continue
+		}
+		if group.IsDeletable(now) {
+			errs = append(errs, deleteGroup(group.GroupID, database))
+			continue
+		}
+		if group.Archived == 0 {
+			errs = append(errs, remindGroup(group, activation.UserID, now, database, notification))
+			errs = append(errs, expireAnnouncements(group, now, database, notification))
+			errs = append(errs, enforceRetention(group, now, database, notification))
+		}
+	}
+	err := errors.Join(errs...)
+	if err == nil && activation.JobID != "" {
+		// The job is complete, unless it will be retried due to an error.
+		err = database.DeleteJob(activation.JobID)
+	}
+	return err
 }
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A job that runs periodically.
+type CronJob struct {
+	// Identifies the job. On AWS Lambda, this is the detail type of the
+	// EventBridge events that run the job (see terraform/eventbridge.tf).
+	Name string
+	// When the job runs, in the format of EventBridge cron expressions
+	// without the year field: "minutes hours day-of-month month day-of-week".
+	// Times are in UTC.
+	Schedule string
+	// Runs the job, as of `now`.
+	Run func(now time.Time, database Database, notification Notification) error
+}
+
+// All periodic jobs. Each must also have an EventBridge rule on AWS.
+var cronJobs = []CronJob{
+	{
+		Name:     "activate",
+		Schedule: "0 * * * ?",
+		Run: func(now time.Time, database Database, notification Notification) error {
+			return activateAt(Activation{}, now, database, notification)
+		},
+	},
+	{
+		// On AWS, DynamoDB expires chat messages itself, so this does nothing.
+		Name:     "delete-expired-messages",
+		Schedule: "30 * * * ?",
+		Run: func(now time.Time, database Database, notification Notification) error {
+			return database.DeleteExpiredMessages(now)
+		},
+	},
+}
+
+// Helper to find a periodic job by name, returning nil if there is none.
+func findCronJob(name string) *CronJob {
+	for i := range cronJobs {
+		if cronJobs[i].Name == name {
+			return &cronJobs[i]
+		}
+	}
+	return nil
+}
+
+// Runs periodic jobs until ctx is canceled.
+//
+// Errors are logged, as the job will have another chance to succeed when it
+// next runs.
+func runCronJobs(ctx context.Context, jobs []CronJob, clock Clock, database Database, notification Notification) error {
+	schedules := make([]*cronSchedule, len(jobs))
+	for i, job := range jobs {
+		schedule, err := parseCronSchedule(job.Schedule)
+		if err != nil {
+			return fmt.Errorf("cron job %s: %w", job.Name, err)
+		}
+		schedules[i] = schedule
+	}
+
+	for {
+		now := clock.Now()
+		var next time.Time
+		for _, schedule := range schedules {
+			date := schedule.Next(now)
+			if !date.IsZero() && (next.IsZero() || date.Before(next)) {
+				next = date
+			}
+		}
+		if next.IsZero() {
+			// No job will ever run.
+			<-ctx.Done()
+			return nil
+		}
+
+		elapsed := make(chan struct{})
+		timer := clock.AfterFunc(next.Sub(now), func() { close(elapsed) })
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-elapsed:
+		}
+
+		for i, job := range jobs {
+			if !schedules[i].Matches(next) {
+				continue
+			}
+			log.Printf("running cron job %s\n", job.Name)
+			if err := job.Run(next, database, notification); err != nil {
+				log.Printf("cron job %s failed: %v\n", job.Name, err)
+			}
+		}
+	}
+}
+
+// A parsed cron expression. Each field is the set of matching values.
+type cronSchedule struct {
+	minutes     [60]bool
+	hours       [24]bool
+	daysOfMonth [32]bool
+	months      [13]bool
+	// Indexed by `time.Weekday`.
+	daysOfWeek [7]bool
+	// Whether each day field was "*" or "?", rather than restricted.
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+// Parses a cron expression like "0 12 ? * 2-6" (noon on weekdays).
+//
+// Each field may be "*", a number, a range like "1-5", a step like "*/15" or
+// "0-30/10", or a comma-separated list of those. Days of the week are 1-7,
+// starting with Sunday. Like EventBridge, at most one of the day fields may
+// be restricted.
+func parseCronSchedule(expression string) (*cronSchedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in %q", expression)
+	}
+	var schedule cronSchedule
+	var daysOfWeek [8]bool
+	for i, field := range []struct {
+		values       []bool
+		min, max     int
+		unrestricted *bool
+	}{
+		{schedule.minutes[:], 0, 59, nil},
+		{schedule.hours[:], 0, 23, nil},
+		{schedule.daysOfMonth[:], 1, 31, &schedule.anyDayOfMonth},
+		{schedule.months[:], 1, 12, nil},
+		{daysOfWeek[:], 1, 7, &schedule.anyDayOfWeek},
+	} {
+		if fields[i] == "*" || fields[i] == "?" {
+			for value := field.min; value <= field.max; value++ {
+				field.values[value] = true
+			}
+			if field.unrestricted != nil {
+				*field.unrestricted = true
+			}
+			continue
+		}
+		for _, part := range strings.Split(fields[i], ",") {
+			if err := parseCronPart(part, field.values, field.min, field.max); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !schedule.anyDayOfMonth && !schedule.anyDayOfWeek {
+		return nil, fmt.Errorf("cannot restrict both day of month and day of week in %q", expression)
+	}
+	for weekday := range schedule.daysOfWeek {
+		schedule.daysOfWeek[weekday] = daysOfWeek[weekday+1]
+	}
+	return &schedule, nil
+}
+
+// Marks the values matched by one part of a cron field, like "1-5/2".
+func parseCronPart(part string, values []bool, min, max int) error {
+	rangePart, stepPart, hasStep := strings.Cut(part, "/")
+	step := 1
+	if hasStep {
+		var err error
+		if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+			return fmt.Errorf("invalid step in %q", part)
+		}
+	}
+	start, end := min, max
+	if rangePart != "*" {
+		startPart, endPart, isRange := strings.Cut(rangePart, "-")
+		var err error
+		if start, err = strconv.Atoi(startPart); err != nil {
+			return fmt.Errorf("invalid value in %q", part)
+		}
+		end = start
+		if isRange {
+			if end, err = strconv.Atoi(endPart); err != nil {
+				return fmt.Errorf("invalid value in %q", part)
+			}
+		} else if hasStep {
+			end = max
+		}
+	}
+	if start < min || end > max || start > end {
+		return fmt.Errorf("value out of range in %q", part)
+	}
+	for value := start; value <= end; value += step {
+		values[value] = true
+	}
+	return nil
+}
+
+// Returns whether the schedule matches the minute containing `date`.
+func (schedule *cronSchedule) Matches(date time.Time) bool {
+	date = date.UTC()
+	return schedule.minutes[date.Minute()] && schedule.hours[date.Hour()] && schedule.months[date.Month()] && schedule.matchesDay(date)
+}
+
+func (schedule *cronSchedule) matchesDay(date time.Time) bool {
+	return schedule.daysOfMonth[date.Day()] && schedule.daysOfWeek[date.Weekday()]
+}
+
+// Returns the first matching minute strictly after `after`.
+func (schedule *cronSchedule) Next(after time.Time) time.Time {
+	date := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	// Every valid schedule matches at least once within a few years.
+	for limit := date.AddDate(8, 0, 0); date.Before(limit); {
+		if !schedule.months[date.Month()] {
+			date = time.Date(date.Year(), date.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		} else if !schedule.matchesDay(date) {
+			date = time.Date(date.Year(), date.Month(), date.Day()+1, 0, 0, 0, 0, time.UTC)
+		} else if !schedule.hours[date.Hour()] {
+			date = date.Truncate(time.Hour).Add(time.Hour)
+		} else if !schedule.minutes[date.Minute()] {
+			date = date.Add(time.Minute)
+		} else {
+			return date
+		}
+	}
+	// Only possible for impossible dates like February 30th.
+	return time.Time{}
+}
@@ -9,7 +9,10 @@ import (
 	"math/rand"
 	"os"
 	"slices"
+	"strings"
 	"sync"
+	"time"
+	"unicode"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -51,19 +54,62 @@ type Database interface {
 	//
 	// Returns an error if the operation could not be completed.
 	UpdateGroup(GroupID, func(group *Group) error) error
-	// Reads group chat messagses, on or after startTime, on or before endTime, and in chronological order,
-	// from the database.
+	// Reads up to `limit` group chat messages, with IDs from start to end (inclusive), in
+	// chronological order, from the database. If `forward` is true, the earliest messages in the
+	// range are read, otherwise the latest. Only replies to `parentID` are read, or messages
+	// outside any thread if it is 0. System messages are skipped unless `system` is true, and
+	// expired messages (see `Message.Expiry`) are always skipped.
+	//
+	// If the returned `bool` is true, there are messages remaining in the range beyond
+	// the last (if `forward`) or first (otherwise) message returned.
+	ReadMessages(groupID GroupID, parentID MessageID, start MessageID, end MessageID, limit int, forward bool, system bool) ([]Message, bool, error)
+	// Deletes a group from the database, if it exists.
 	//
-	// May not return all messages. If the returned `bool` is true, there may be
-	// messages remaining (set `endTime` to the earliest `message.Timestamp` and try again).
-	ReadMessages(GroupID, startTime UnixMillis, endTime UnixMillis) ([]Message, bool, error)
 	// Returns an error if the operation could not be completed.
 	DeleteGroup(GroupID) error
+	// Reads the IDs of all groups, in no particular order.
+	//
+	// Returns an error if the operation could not be completed.
+	ReadGroupIDs() ([]GroupID, error)
 	// Creates a new chat message in the group.
 	//
-	// Returns an error if the `message.GroupID` and `message.Timestamp` are not
+	// Returns an error if the `message.GroupID` and `message.MessageID` are not
 	// unique, or if the operation could not be completed.
 	CreateMessage(Message) error
+	// Reads a chat message from the database.
+	//
+	// Returns a nil `*Message` if no such message exists. Returns
+	// an error if the operation could not be completed.
+	ReadMessage(GroupID, MessageID) (*Message, error)
+	// Transactionally updates a chat message.
+	//
+	// Returns an error if no such message exists, or if the operation could
+	// not be completed.
+	UpdateMessage(GroupID, MessageID, func(message *Message) error) error
+	// Searches a group's chat messages, including replies, returning up to
+	// `limit` matches from latest to earliest.
+	//
+	// If the returned `MessageID` is not 0, there may be more matches with IDs
+	// up to it (inclusive). It may be before the last message returned, or the
+	// end of the range if none were, when too many messages that didn't match
+	// had to be checked.
+	SearchMessages(groupID GroupID, search MessageSearch, limit int) ([]Message, MessageID, error)
+	// Deletes a group's chat messages (including replies) with IDs up to `end`
+	// (inclusive), if any, returning whether there were any.
+	//
+	// Returns an error if the operation could not be completed.
+	DeleteMessages(groupID GroupID, end MessageID) (bool, error)
+	// Sets the expiry (see `Message.Expiry`) of each of a group's chat messages
+	// (including replies) that hasn't expired to `expiry(message.Timestamp)`,
+	// such as after the group's retention setting changed.
+	//
+	// Returns an error if the operation could not be completed.
+	UpdateMessageExpiry(groupID GroupID, expiry func(timestamp UnixMillis) int64) error
+	// Deletes the chat messages of all groups that expired by `now`. DynamoDB
+	// does this itself, so its implementation does nothing.
+	//
+	// Returns an error if the operation could not be completed.
+	DeleteExpiredMessages(now time.Time) error
 	// Reads the UserID associated with a connection, or returns nil if none exists.
 	//
 	// Returns an error if the operation could not be completed.
@@ -76,25 +122,142 @@ type Database interface {
 	//
 	// Returns an error if the operation could not be completed.
 	DeleteConnection(ConnectionID) error
+	// Creates a new session in the database.
+	//
+	// Returns an error if a session with the same `SessionID`
+	// already exists, or if the operation may have failed.
+	CreateSession(Session) error
+	// Reads a session from the database, which may be expired.
+	//
+	// Returns a nil `*Session` if no such session exists. Returns
+	// an error if the operation could not be completed.
+	ReadSession(SessionID) (*Session, error)
+	// Deletes a session from the database, if it exists.
+	//
+	// Returns an error if the operation could not be completed.
+	DeleteSession(SessionID) error
+	// Writes or overwrites a scheduled job.
+	//
+	// Returns an error if the operation could not be completed.
+	WriteJob(Job) error
+	// Reads a scheduled job from the database.
+	//
+	// Returns a nil `*Job` if no such job exists. Returns
+	// an error if the operation could not be completed.
+	ReadJob(JobID) (*Job, error)
+	// Reads all scheduled jobs, in no particular order.
+	//
+	// Returns an error if the operation could not be completed.
+	ReadJobs() ([]Job, error)
+	// Deletes a scheduled job, if it exists.
+	//
+	// Returns an error if the operation could not be completed.
+	DeleteJob(JobID) error
 	// Reads the value of a variable (possibly empty string if empty or nonexistent).
 	ReadVariable(string) (string, error)
-	// Overwrites the value of a variable.
+	// Writes the value of a variable that doesn't have one yet.
+	//
+	// Returns an error if the variable already has a value (e.g. because
+	// another instance wrote it first), or if the operation could not be
+	// completed.
 	WriteVariable(string, string) error
 }
 
+// The longest word of a chat message that can be searched for, in bytes.
+const searchTermMaxLen = 64
+
+const (
+	// How many messages DynamoDB reads at a time while searching.
+	searchBatchSize = 100
+	// How many messages DynamoDB checks for each page of search results.
+	searchMaxCandidates = 500
+)
+
+// Criteria for searching chat messages.
+type MessageSearch struct {
+	// Words that must all appear in a message (see `searchTerms`). Must not be
+	// empty.
+	Terms []string
+	// If not 0, the sender of the message.
+	Sender UserID
+	// The range of message IDs (inclusive).
+	Start MessageID
+	End   MessageID
+}
+
+// Returns whether a message meets the criteria. Deleted, system and expired
+// messages never do.
+func (search *MessageSearch) Matches(message Message) bool {
+	if message.Deleted || message.Kind != "" || message.IsExpired(time.Now()) || message.MessageID < search.Start || message.MessageID > search.End || (search.Sender != 0 && message.Sender != search.Sender) {
+		return false
+	}
+	terms := searchTerms(message.Content)
+	for _, term := range search.Terms {
+		if !slices.Contains(terms, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// Helper to check if a message has expired by `now`, even if it hasn't been
+// deleted yet.
+func (message *Message) IsExpired(now time.Time) bool {
+	return message.Expiry != 0 && message.Expiry <= now.Unix()
+}
+
+// Splits text into the distinct words that can be searched for, in lowercase.
+func searchTerms(text string) []string {
+	var terms []string
+	for _, term := range searchWords(text) {
+		if len(term) <= searchTermMaxLen && !slices.Contains(terms, term) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// Splits text into words, in lowercase, including ones too long to search for.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !isSearchRune(r) })
+}
+
+func isSearchRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Returns the terms in `terms` but not in `without`.
+func termsWithout(terms []string, without []string) []string {
+	return slices.DeleteFunc(slices.Clone(terms), func(term string) bool { return slices.Contains(without, term) })
+}
+
 // An AWS non-volatile database service.
 type DynamoDB struct {
-	groups      dynamo.Table
-	users       dynamo.Table
-	messages    dynamo.Table
-	connections dynamo.Table
-	variables   dynamo.Table
+	groups       dynamo.Table
+	users        dynamo.Table
+	messages     dynamo.Table
+	messageIndex dynamo.Table
+	// Only read by `MigrateLegacyMessages`.
+	legacyMessages dynamo.Table
+	connections    dynamo.Table
+	sessions       dynamo.Table
+	jobs           dynamo.Table
+	variables      dynamo.Table
 }
 
 const groupTableName = "lemmeknow-groups"
 const userTableName = "lemmeknow-users"
-const messageTableName = "lemmeknow-messages"
+const messageTableName = "lemmeknow-messages-v2"
+
+// Messages from before they had IDs, keyed by timestamp instead.
+const legacyMessageTableName = "lemmeknow-messages"
+const messageIndexTableName = "lemmeknow-message-index"
+
+// Indexes messages by `Message.Thread`.
+const messageThreadIndexName = "Thread-index"
 const connectionTableName = "lemmeknow-connections"
+const sessionTableName = "lemmeknow-sessions"
+const jobTableName = "lemmeknow-jobs"
 const variableTableName = "lemmeknow-variables"
 
 // Passing a `nil` session means use DynamoDB local (default port).
@@ -118,19 +281,26 @@ func NewDynamoDB(sess *session.Session) *DynamoDB {
 		// Ingnore errors (e.g. duplicate table)
 		_ = db.CreateTable(groupTableName, Group{}).Run()
 		_ = db.CreateTable(userTableName, User{}).Run()
-		_ = db.CreateTable(messageTableName, Message{}).Run()
+		_ = db.CreateTable(messageTableName, Message{}).Project(messageThreadIndexName, dynamo.AllProjection).Run()
+		_ = db.CreateTable(messageIndexTableName, MessageIndexEntry{}).Run()
 		_ = db.CreateTable(connectionTableName, Connection{}).Run()
+		_ = db.CreateTable(sessionTableName, Session{}).Run()
+		_ = db.CreateTable(jobTableName, Job{}).Run()
 		_ = db.CreateTable(variableTableName, Variable{}).Run()
 	} else {
 		db = dynamo.New(sess, &aws.Config{Region: aws.String(GetRegion())})
 	}
 
 	return &DynamoDB{
-		groups:      db.Table(groupTableName),
-		users:       db.Table(userTableName),
-		messages:    db.Table(messageTableName),
-		connections: db.Table(connectionTableName),
-		variables:   db.Table(variableTableName),
+		groups:         db.Table(groupTableName),
+		users:          db.Table(userTableName),
+		messages:       db.Table(messageTableName),
+		messageIndex:   db.Table(messageIndexTableName),
+		legacyMessages: db.Table(legacyMessageTableName),
+		connections:    db.Table(connectionTableName),
+		sessions:       db.Table(sessionTableName),
+		jobs:           db.Table(jobTableName),
+		variables:      db.Table(variableTableName),
 	}
 }
 
@@ -190,7 +360,7 @@ func (dynamoDB *DynamoDB) UpdateUser(userID UserID, transaction func(*User) erro
 //
 // Returns an error if the operation could not be completed.
 func (dynamoDB *DynamoDB) DeleteUser(userID UserID) error {
-	return dynamoDB.users.Delete("UserID", userID).If("attribute_exists(UserID)").Run()
+	return dynamoDB.users.Delete("UserID", userID).Run()
 }
 
 // Creates a new group in the database.
@@ -245,28 +415,301 @@ func (dynamoDB *DynamoDB) UpdateGroup(groupID GroupID, transaction func(*Group)
 	}
 }
 
-func (dynamoDB *DynamoDB) ReadMessages(groupID GroupID, startTime UnixMillis, endTime UnixMillis) ([]Message, bool, error) {
+func (dynamoDB *DynamoDB) ReadMessages(groupID GroupID, parentID MessageID, start MessageID, end MessageID, limit int, forward bool, system bool) ([]Message, bool, error) {
 	var messages []Message
-	const limit = 5
-	// Iterate in reverse to get the recent ones first.
-	err := dynamoDB.messages.Get("GroupID", groupID).Range("Timestamp", "BETWEEN", startTime, endTime).Consistent(true).Order(dynamo.Descending).Limit(limit).All(&messages)
+	order := dynamo.Ascending
+	if !forward {
+		// Iterate in reverse to get the recent ones first.
+		order = dynamo.Descending
+	}
+	// Read one extra message to find out if any remain.
+	// The filter doesn't count towards the limit, which applies to the results.
+	query := dynamoDB.messages.Get("Thread", messageThread(groupID, parentID)).Index(messageThreadIndexName).Range("MessageID", "BETWEEN", start, end)
+	if !system {
+		// Empty strings aren't stored.
+		query = query.Filter("attribute_not_exists($)", "Kind")
+	}
+	// DynamoDB may take a while to delete expired messages.
+	query = query.Filter("attribute_not_exists($) OR $ > ?", "Expiry", "Expiry", time.Now().Unix())
+	// Indexes are only eventually consistent, so a message may take a moment
+	// to be read after it is created.
+	err := query.Order(order).Limit(int64(limit + 1)).All(&messages)
+	more := len(messages) > limit
+	messages = messages[:min(len(messages), limit)]
 	// Sort in chronological order again.
 	slices.SortFunc(messages, func(a Message, b Message) int {
-		return cmp.Compare(a.Timestamp, b.Timestamp)
+		return cmp.Compare(a.MessageID, b.MessageID)
 	})
-	return messages, len(messages) >= limit, err
+	return messages, more, err
 }
 
 // Deletes a group from the database, if it exists.
 //
 // Returns an error if the operation could not be completed.
 func (dynamoDB *DynamoDB) DeleteGroup(groupID GroupID) error {
-	err := dynamoDB.groups.Delete("GroupID", groupID).If("attribute_exists(GroupID)").Run()
-	return err
+	return dynamoDB.groups.Delete("GroupID", groupID).Run()
+}
+
+func (dynamoDB *DynamoDB) ReadGroupIDs() ([]GroupID, error) {
+	var groups []Group
+	err := dynamoDB.groups.Scan().Project("GroupID").Consistent(true).All(&groups)
+	groupIDs := make([]GroupID, len(groups))
+	for i := range groups {
+		groupIDs[i] = groups[i].GroupID
+	}
+	return groupIDs, err
 }
 
 func (dynamoDB *DynamoDB) CreateMessage(message Message) error {
-	return dynamoDB.messages.Put(message).If("attribute_not_exists($)", "Timestamp").Run()
+	message.Thread = messageThread(message.GroupID, message.ParentID)
+	if err := dynamoDB.messages.Put(message).If("attribute_not_exists($)", "MessageID").Run(); err != nil {
+		return err
+	}
+	// The message was sent regardless, so don't fail (and get retried).
+	dynamoDB.indexMessage(message, nil, searchTerms(message.Content))
+	return nil
+}
+
+// A chat message in the legacy table (see `MigrateLegacyMessages`).
+type legacyMessage struct {
+	GroupID   GroupID `dynamo:",hash"`
+	Timestamp uint64  `dynamo:",range"`
+	Content   string
+	Sender    UserID
+}
+
+// Copies chat messages from the legacy table, keyed by timestamp, to the
+// current one, giving each the lowest ID for its timestamp. Messages that were
+// already copied are skipped, so it is safe to run again after an error.
+//
+// Returns the number of messages copied.
+func (dynamoDB *DynamoDB) MigrateLegacyMessages() (int, error) {
+	copied := 0
+	iter := dynamoDB.legacyMessages.Scan().Iter()
+	var legacy legacyMessage
+	for iter.Next(&legacy) {
+		message := Message{
+			GroupID:   legacy.GroupID,
+			MessageID: firstMessageID(legacy.Timestamp),
+			Timestamp: legacy.Timestamp,
+			Content:   legacy.Content,
+			Sender:    legacy.Sender,
+		}
+		if err := dynamoDB.CreateMessage(message); err != nil {
+			if dynamo.IsCondCheckFailed(err) {
+				continue
+			}
+			return copied, err
+		}
+		copied++
+	}
+	return copied, iter.Err()
+}
+
+// Identifies a thread within a group, like "1234/5678", or "1234/0" for
+// messages outside any thread (see `Message.Thread`).
+func messageThread(groupID GroupID, parentID MessageID) string {
+	return fmt.Sprintf("%d/%d", groupID, parentID)
+}
+
+// Identifies a search term within a group, like "1234/airbnb".
+func messageIndexTerm(groupID GroupID, term string) string {
+	return fmt.Sprintf("%d/%s", groupID, term)
+}
+
+// Updates the search index for a message whose terms changed.
+//
+// Errors are logged, as the message itself was already written. Until it is
+// next edited, it may not be found by (or may wrongly be checked by) searches.
+func (dynamoDB *DynamoDB) indexMessage(message Message, oldTerms []string, newTerms []string) {
+	var puts []any
+	for _, term := range termsWithout(newTerms, oldTerms) {
+		puts = append(puts, MessageIndexEntry{Term: messageIndexTerm(message.GroupID, term), MessageID: message.MessageID, Expiry: message.Expiry})
+	}
+	var deletes []dynamo.Keyed
+	for _, term := range termsWithout(oldTerms, newTerms) {
+		deletes = append(deletes, dynamo.Keys{messageIndexTerm(message.GroupID, term), message.MessageID})
+	}
+	if len(puts) == 0 && len(deletes) == 0 {
+		return
+	}
+	batch := dynamoDB.messageIndex.Batch("Term", "MessageID").Write()
+	if len(puts) > 0 {
+		batch = batch.Put(puts...)
+	}
+	if len(deletes) > 0 {
+		batch = batch.Delete(deletes...)
+	}
+	if _, err := batch.Run(); err != nil {
+		log.Printf("could not index message groupID=%d messageID=%d: %v\n", message.GroupID, message.MessageID, err)
+	}
+}
+
+func (dynamoDB *DynamoDB) SearchMessages(groupID GroupID, search MessageSearch, limit int) ([]Message, MessageID, error) {
+	// Every match has every term, so check each message that has the longest
+	// (likely the rarest).
+	term := slices.MaxFunc(search.Terms, func(a string, b string) int {
+		return cmp.Compare(len(a), len(b))
+	})
+	iter := dynamoDB.messageIndex.Get("Term", messageIndexTerm(groupID, term)).Range("MessageID", "BETWEEN", search.Start, search.End).Order(dynamo.Descending).Iter()
+	var messages []Message
+	checked := 0
+	for {
+		var keys []dynamo.Keyed
+		var entry MessageIndexEntry
+		for len(keys) < searchBatchSize && iter.Next(&entry) {
+			keys = append(keys, dynamo.Keys{groupID, entry.MessageID})
+		}
+		// The earliest message in the batch.
+		last := entry.MessageID
+		if err := iter.Err(); err != nil {
+			return nil, 0, err
+		}
+		if len(keys) == 0 {
+			return messages, 0, nil
+		}
+
+		var candidates []Message
+		err := dynamoDB.messages.Batch("GroupID", "MessageID").Get(keys...).Consistent(true).All(&candidates)
+		if err != nil && !errors.Is(err, dynamo.ErrNotFound) {
+			return nil, 0, err
+		}
+		slices.SortFunc(candidates, func(a Message, b Message) int {
+			return cmp.Compare(b.MessageID, a.MessageID)
+		})
+		for _, message := range candidates {
+			// The index may be briefly out of date.
+			if !search.Matches(message) {
+				continue
+			}
+			if len(messages) == limit {
+				return messages, message.MessageID, nil
+			}
+			messages = append(messages, message)
+		}
+
+		checked += len(keys)
+		if checked >= searchMaxCandidates && len(keys) == searchBatchSize {
+			// Continue just before the last message checked.
+			if last <= search.Start {
+				return messages, 0, nil
+			}
+			return messages, last - 1, nil
+		}
+	}
+}
+
+func (dynamoDB *DynamoDB) ReadMessage(groupID GroupID, messageID MessageID) (*Message, error) {
+	var message Message
+	err := dynamoDB.messages.Get("GroupID", groupID).Range("MessageID", dynamo.Equal, messageID).Consistent(true).One(&message)
+
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return nil, nil
+	}
+	return &message, err
+}
+
+func (dynamoDB *DynamoDB) UpdateMessage(groupID GroupID, messageID MessageID, transaction func(*Message) error) error {
+	governor := 0
+	for {
+		message, err := dynamoDB.ReadMessage(groupID, messageID)
+		if err != nil {
+			return err
+		}
+		if message == nil {
+			return fmt.Errorf("message not found")
+		}
+
+		oldCount := message.UpdateCount
+		oldTerms := searchTerms(message.Content)
+		if err := transaction(message); err != nil {
+			return err
+		}
+		message.UpdateCount = oldCount + 1
+
+		err = dynamoDB.messages.Put(message).If("UpdateCount = ?", oldCount).Run()
+		if err != nil && dynamo.IsCondCheckFailed(err) {
+			// Retry the transaction.
+			governor += 1
+			if governor > 16 {
+				return fmt.Errorf("too many retries")
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		dynamoDB.indexMessage(*message, oldTerms, searchTerms(message.Content))
+		return nil
+	}
+}
+
+func (dynamoDB *DynamoDB) DeleteMessages(groupID GroupID, end MessageID) (bool, error) {
+	var messages []Message
+	err := dynamoDB.messages.Get("GroupID", groupID).Range("MessageID", dynamo.LessOrEqual, end).Consistent(true).All(&messages)
+	if err != nil || len(messages) == 0 {
+		return false, err
+	}
+	keys := make([]dynamo.Keyed, 0, len(messages))
+	var indexKeys []dynamo.Keyed
+	for _, message := range messages {
+		keys = append(keys, dynamo.Keys{message.GroupID, message.MessageID})
+		for _, term := range searchTerms(message.Content) {
+			indexKeys = append(indexKeys, dynamo.Keys{messageIndexTerm(groupID, term), message.MessageID})
+		}
+	}
+	if len(indexKeys) > 0 {
+		if _, err := dynamoDB.messageIndex.Batch("Term", "MessageID").Write().Delete(indexKeys...).Run(); err != nil {
+			return false, err
+		}
+	}
+	_, err = dynamoDB.messages.Batch("GroupID", "MessageID").Write().Delete(keys...).Run()
+	return err == nil, err
+}
+
+func (dynamoDB *DynamoDB) UpdateMessageExpiry(groupID GroupID, expiry func(timestamp UnixMillis) int64) error {
+	now := time.Now()
+	var messages []Message
+	err := dynamoDB.messages.Get("GroupID", groupID).Project("MessageID", "Timestamp", "Content", "Expiry").Consistent(true).All(&messages)
+	if err != nil {
+		return err
+	}
+	for _, message := range messages {
+		newExpiry := expiry(message.Timestamp)
+		if message.IsExpired(now) || newExpiry == message.Expiry {
+			continue
+		}
+		// Count the update, so that concurrent edits retry with the new
+		// expiry rather than overwriting it.
+		update := dynamoDB.messages.Update("GroupID", groupID).Range("MessageID", message.MessageID).Add("UpdateCount", 1).If("attribute_exists(MessageID)")
+		if newExpiry == 0 {
+			update = update.Remove("Expiry")
+		} else {
+			update = update.Set("Expiry", newExpiry)
+		}
+		if err := update.Run(); err != nil {
+			if dynamo.IsCondCheckFailed(err) {
+				// Deleted in the meantime.
+				continue
+			}
+			return err
+		}
+		var puts []any
+		for _, term := range searchTerms(message.Content) {
+			puts = append(puts, MessageIndexEntry{Term: messageIndexTerm(groupID, term), MessageID: message.MessageID, Expiry: newExpiry})
+		}
+		if len(puts) > 0 {
+			if _, err := dynamoDB.messageIndex.Batch("Term", "MessageID").Write().Put(puts...).Run(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (dynamoDB *DynamoDB) DeleteExpiredMessages(now time.Time) error {
+	// Done by DynamoDB's time to live.
+	return nil
 }
 
 func (dynamoDB *DynamoDB) ReadConnection(connectionID ConnectionID) (*UserID, error) {
@@ -284,12 +727,53 @@ func (dynamoDB *DynamoDB) WriteConnection(connectionID ConnectionID, userID User
 		ConnectionID: connectionID,
 		UserID:       userID,
 	}
-	return dynamoDB.connections.Put(connection).If("attribute_not_exists(ConnectionID)").Run()
+	return dynamoDB.connections.Put(connection).Run()
 }
 
 func (dynamoDB *DynamoDB) DeleteConnection(connectionID ConnectionID) error {
-	err := dynamoDB.connections.Delete("ConnectionID", connectionID).If("attribute_exists(ConnectionID)").Run()
-	return err
+	return dynamoDB.connections.Delete("ConnectionID", connectionID).Run()
+}
+
+func (dynamoDB *DynamoDB) CreateSession(session Session) error {
+	return dynamoDB.sessions.Put(session).If("attribute_not_exists(SessionID)").Run()
+}
+
+func (dynamoDB *DynamoDB) ReadSession(sessionID SessionID) (*Session, error) {
+	var session Session
+	err := dynamoDB.sessions.Get("SessionID", sessionID).Consistent(true).One(&session)
+
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return nil, nil
+	}
+	return &session, err
+}
+
+func (dynamoDB *DynamoDB) DeleteSession(sessionID SessionID) error {
+	return dynamoDB.sessions.Delete("SessionID", sessionID).Run()
+}
+
+func (dynamoDB *DynamoDB) WriteJob(job Job) error {
+	return dynamoDB.jobs.Put(job).Run()
+}
+
+func (dynamoDB *DynamoDB) ReadJob(jobID JobID) (*Job, error) {
+	var job Job
+	err := dynamoDB.jobs.Get("JobID", jobID).Consistent(true).One(&job)
+
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return nil, nil
+	}
+	return &job, err
+}
+
+func (dynamoDB *DynamoDB) ReadJobs() ([]Job, error) {
+	var jobs []Job
+	err := dynamoDB.jobs.Scan().Consistent(true).All(&jobs)
+	return jobs, err
+}
+
+func (dynamoDB *DynamoDB) DeleteJob(jobID JobID) error {
+	return dynamoDB.jobs.Delete("JobID", jobID).Run()
 }
 
 func (dynamoDB *DynamoDB) ReadVariable(name string) (string, error) {
@@ -318,17 +802,26 @@ func printDatabase(database Database) error {
 
 // An in-memory volatile database.
 type MemoryDatabase struct {
-	users       map[UserID]User
-	groups      map[GroupID]Group
-	messages    map[memoryMessageID]Message
+	users    map[UserID]User
+	groups   map[GroupID]Group
+	messages map[memoryMessageID]Message
+	// IDs of the messages containing each search term.
+	searchIndex map[memorySearchTerm]map[MessageID]struct{}
 	connections map[ConnectionID]UserID
+	sessions    map[SessionID]Session
+	jobs        map[JobID]Job
 	variables   map[string]string
 	mu          sync.Mutex
 }
 
 type memoryMessageID struct {
 	GroupID   GroupID
-	Timestamp uint64
+	MessageID MessageID
+}
+
+type memorySearchTerm struct {
+	GroupID GroupID
+	Term    string
 }
 
 func NewMemoryDatabase() *MemoryDatabase {
@@ -336,7 +829,10 @@ func NewMemoryDatabase() *MemoryDatabase {
 		users:       make(map[UserID]User),
 		groups:      make(map[GroupID]Group),
 		messages:    make(map[memoryMessageID]Message),
+		searchIndex: make(map[memorySearchTerm]map[MessageID]struct{}),
 		connections: make(map[ConnectionID]UserID),
+		sessions:    make(map[SessionID]Session),
+		jobs:        make(map[JobID]Job),
 		variables:   make(map[string]string),
 	}
 }
@@ -418,22 +914,29 @@ func (memoryDatabase *MemoryDatabase) UpdateGroup(groupID GroupID, transaction f
 	return nil
 }
 
-func (memoryDatabase *MemoryDatabase) ReadMessages(groupID GroupID, startTime UnixMillis, endTime UnixMillis) ([]Message, bool, error) {
+func (memoryDatabase *MemoryDatabase) ReadMessages(groupID GroupID, parentID MessageID, start MessageID, end MessageID, limit int, forward bool, system bool) ([]Message, bool, error) {
 	memoryDatabase.mu.Lock()
 	defer memoryDatabase.mu.Unlock()
 	var messages []Message
+	now := time.Now()
 	// Okay to do inefficient linear table scan on mock database.
 	for _, message := range memoryDatabase.messages {
-		if message.GroupID != groupID || message.Timestamp < startTime || message.Timestamp > endTime {
+		if message.GroupID != groupID || message.ParentID != parentID || message.MessageID < start || message.MessageID > end || (!system && message.Kind != "") || message.IsExpired(now) {
 			continue
 		}
 		messages = append(messages, message)
 	}
 	slices.SortFunc(messages, func(a Message, b Message) int {
-		return cmp.Compare(a.Timestamp, b.Timestamp)
+		return cmp.Compare(a.MessageID, b.MessageID)
 	})
-	first := max(len(messages)-5, 0)
-	return messages[first:], first > 0, nil
+	more := len(messages) > limit
+	if !more {
+		return messages, false, nil
+	}
+	if forward {
+		return messages[:limit], true, nil
+	}
+	return messages[len(messages)-limit:], true, nil
 }
 
 func (memoryDatabase *MemoryDatabase) DeleteGroup(groupID GroupID) error {
@@ -443,10 +946,20 @@ func (memoryDatabase *MemoryDatabase) DeleteGroup(groupID GroupID) error {
 	return nil
 }
 
+func (memoryDatabase *MemoryDatabase) ReadGroupIDs() ([]GroupID, error) {
+	memoryDatabase.mu.Lock()
+	defer memoryDatabase.mu.Unlock()
+	groupIDs := []GroupID{}
+	for groupID := range memoryDatabase.groups {
+		groupIDs = append(groupIDs, groupID)
+	}
+	return groupIDs, nil
+}
+
 func (memoryDatabase *MemoryDatabase) CreateMessage(message Message) error {
 	id := memoryMessageID{
 		GroupID:   message.GroupID,
-		Timestamp: message.Timestamp,
+		MessageID: message.MessageID,
 	}
 	memoryDatabase.mu.Lock()
 	defer memoryDatabase.mu.Unlock()
@@ -454,9 +967,124 @@ func (memoryDatabase *MemoryDatabase) CreateMessage(message Message) error {
 		return fmt.Errorf("message already exists")
 	}
 	memoryDatabase.messages[id] = message
+	memoryDatabase.indexMessage(message.GroupID, message.MessageID, nil, searchTerms(message.Content))
+	return nil
+}
+
+// Updates the search index for a message whose terms changed. The caller
+// must hold the lock.
+func (memoryDatabase *MemoryDatabase) indexMessage(groupID GroupID, messageID MessageID, oldTerms []string, newTerms []string) {
+	for _, term := range termsWithout(oldTerms, newTerms) {
+		key := memorySearchTerm{GroupID: groupID, Term: term}
+		delete(memoryDatabase.searchIndex[key], messageID)
+		if len(memoryDatabase.searchIndex[key]) == 0 {
+			delete(memoryDatabase.searchIndex, key)
+		}
+	}
+	for _, term := range termsWithout(newTerms, oldTerms) {
+		key := memorySearchTerm{GroupID: groupID, Term: term}
+		if memoryDatabase.searchIndex[key] == nil {
+			memoryDatabase.searchIndex[key] = make(map[MessageID]struct{})
+		}
+		memoryDatabase.searchIndex[key][messageID] = struct{}{}
+	}
+}
+
+func (memoryDatabase *MemoryDatabase) SearchMessages(groupID GroupID, search MessageSearch, limit int) ([]Message, MessageID, error) {
+	memoryDatabase.mu.Lock()
+	defer memoryDatabase.mu.Unlock()
+	var messages []Message
+	// Every match has the first term, so check each message that does.
+	for messageID := range memoryDatabase.searchIndex[memorySearchTerm{GroupID: groupID, Term: search.Terms[0]}] {
+		message := memoryDatabase.messages[memoryMessageID{GroupID: groupID, MessageID: messageID}]
+		if search.Matches(message) {
+			messages = append(messages, message)
+		}
+	}
+	slices.SortFunc(messages, func(a Message, b Message) int {
+		return cmp.Compare(b.MessageID, a.MessageID)
+	})
+	if len(messages) > limit {
+		return messages[:limit], messages[limit].MessageID, nil
+	}
+	return messages, 0, nil
+}
+
+func (memoryDatabase *MemoryDatabase) ReadMessage(groupID GroupID, messageID MessageID) (*Message, error) {
+	memoryDatabase.mu.Lock()
+	defer memoryDatabase.mu.Unlock()
+	message, ok := memoryDatabase.messages[memoryMessageID{GroupID: groupID, MessageID: messageID}]
+	if !ok {
+		return nil, nil
+	}
+	return &message, nil
+}
+
+func (memoryDatabase *MemoryDatabase) UpdateMessage(groupID GroupID, messageID MessageID, transaction func(*Message) error) error {
+	id := memoryMessageID{
+		GroupID:   groupID,
+		MessageID: messageID,
+	}
+	memoryDatabase.mu.Lock()
+	defer memoryDatabase.mu.Unlock()
+	message, ok := memoryDatabase.messages[id]
+	if !ok {
+		return fmt.Errorf("message not found")
+	}
+	oldTerms := searchTerms(message.Content)
+	if err := transaction(&message); err != nil {
+		return err
+	}
+	memoryDatabase.messages[id] = message
+	memoryDatabase.indexMessage(groupID, messageID, oldTerms, searchTerms(message.Content))
+	return nil
+}
+
+func (memoryDatabase *MemoryDatabase) DeleteMessages(groupID GroupID, end MessageID) (bool, error) {
+	memoryDatabase.mu.Lock()
+	defer memoryDatabase.mu.Unlock()
+	return memoryDatabase.deleteMessagesFunc(func(message Message) bool {
+		return message.GroupID == groupID && message.MessageID <= end
+	}), nil
+}
+
+func (memoryDatabase *MemoryDatabase) UpdateMessageExpiry(groupID GroupID, expiry func(timestamp UnixMillis) int64) error {
+	memoryDatabase.mu.Lock()
+	defer memoryDatabase.mu.Unlock()
+	now := time.Now()
+	for id, message := range memoryDatabase.messages {
+		if message.GroupID != groupID || message.IsExpired(now) {
+			continue
+		}
+		message.Expiry = expiry(message.Timestamp)
+		memoryDatabase.messages[id] = message
+	}
 	return nil
 }
 
+func (memoryDatabase *MemoryDatabase) DeleteExpiredMessages(now time.Time) error {
+	memoryDatabase.mu.Lock()
+	defer memoryDatabase.mu.Unlock()
+	memoryDatabase.deleteMessagesFunc(func(message Message) bool {
+		return message.IsExpired(now)
+	})
+	return nil
+}
+
+// Deletes the messages for which `del` returns true, and their search terms,
+// returning whether there were any. The caller must hold the lock.
+func (memoryDatabase *MemoryDatabase) deleteMessagesFunc(del func(Message) bool) bool {
+	deleted := false
+	for id, message := range memoryDatabase.messages {
+		if del(message) {
+			memoryDatabase.indexMessage(id.GroupID, id.MessageID, searchTerms(message.Content), nil)
+			delete(memoryDatabase.messages, id)
+			deleted = true
+		}
+	}
+	return deleted
+}
+
 func (memoryDatabase *MemoryDatabase) ReadConnection(connectionID ConnectionID) (*UserID, error) {
 	memoryDatabase.mu.Lock()
 	defer memoryDatabase.mu.Unlock()
@@ -482,6 +1110,69 @@ func (memoryDatabase *MemoryDatabase) DeleteConnection(connectionID ConnectionID
 	return nil
 }
 
+func (memoryDatabase *MemoryDatabase) CreateSession(session Session) error {
+	memoryDatabase.mu.Lock()
+	defer memoryDatabase.mu.Unlock()
+	if _, ok := memoryDatabase.sessions[session.SessionID]; ok {
+		return fmt.Errorf("session already exists")
+	}
+	memoryDatabase.sessions[session.SessionID] = session
+	return nil
+}
+
+func (memoryDatabase *MemoryDatabase) ReadSession(sessionID SessionID) (*Session, error) {
+	memoryDatabase.mu.Lock()
+	defer memoryDatabase.mu.Unlock()
+	session, ok := memoryDatabase.sessions[sessionID]
+	if ok {
+		return &session, nil
+	} else {
+		return nil, nil
+	}
+}
+
+func (memoryDatabase *MemoryDatabase) DeleteSession(sessionID SessionID) error {
+	memoryDatabase.mu.Lock()
+	defer memoryDatabase.mu.Unlock()
+	delete(memoryDatabase.sessions, sessionID)
+	return nil
+}
+
+func (memoryDatabase *MemoryDatabase) WriteJob(job Job) error {
+	memoryDatabase.mu.Lock()
+	defer memoryDatabase.mu.Unlock()
+	memoryDatabase.jobs[job.JobID] = job
+	return nil
+}
+
+func (memoryDatabase *MemoryDatabase) ReadJob(jobID JobID) (*Job, error) {
+	memoryDatabase.mu.Lock()
+	defer memoryDatabase.mu.Unlock()
+	job, ok := memoryDatabase.jobs[jobID]
+	if ok {
+		return &job, nil
+	} else {
+		return nil, nil
+	}
+}
+
+func (memoryDatabase *MemoryDatabase) ReadJobs() ([]Job, error) {
+	memoryDatabase.mu.Lock()
+	defer memoryDatabase.mu.Unlock()
+	jobs := []Job{}
+	for _, job := range memoryDatabase.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (memoryDatabase *MemoryDatabase) DeleteJob(jobID JobID) error {
+	memoryDatabase.mu.Lock()
+	defer memoryDatabase.mu.Unlock()
+	delete(memoryDatabase.jobs, jobID)
+	return nil
+}
+
 func (memoryDatabase *MemoryDatabase) ReadVariable(name string) (string, error) {
 	memoryDatabase.mu.Lock()
 	defer memoryDatabase.mu.Unlock()
@@ -496,6 +1187,9 @@ func (memoryDatabase *MemoryDatabase) ReadVariable(name string) (string, error)
 func (memoryDatabase *MemoryDatabase) WriteVariable(name string, value string) error {
 	memoryDatabase.mu.Lock()
 	defer memoryDatabase.mu.Unlock()
+	if _, ok := memoryDatabase.variables[name]; ok {
+		return fmt.Errorf("variable already exists")
+	}
 	memoryDatabase.variables[name] = value
 	return nil
 }
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// A non-volatile database stored in a local file, for development.
+type BoltDatabase struct {
+	db *bolt.DB
+}
+
+var (
+	boltUserBucket       = []byte("users")
+	boltGroupBucket      = []byte("groups")
+	boltMessageBucket    = []byte("messages")
+	boltSearchBucket     = []byte("search")
+	boltConnectionBucket = []byte("connections")
+	boltSessionBucket    = []byte("sessions")
+	boltJobBucket        = []byte("jobs")
+	boltVariableBucket   = []byte("variables")
+)
+
+// Opens or creates a database file at `path`.
+//
+// Returns an error if the file is in use by another process.
+func NewBoltDatabase(path string) (*BoltDatabase, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{boltUserBucket, boltGroupBucket, boltMessageBucket, boltSearchBucket, boltConnectionBucket, boltSessionBucket, boltJobBucket, boltVariableBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		// Connections don't survive a restart.
+		if err := tx.DeleteBucket(boltConnectionBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(boltConnectionBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltDatabase{db: db}, nil
+}
+
+// Closes the database file.
+func (boltDatabase *BoltDatabase) Close() error {
+	return boltDatabase.db.Close()
+}
+
+// Encodes an ID as a key that sorts in numerical order.
+func boltKey(ids ...uint64) []byte {
+	key := make([]byte, 0, 8*len(ids))
+	for _, id := range ids {
+		key = binary.BigEndian.AppendUint64(key, id)
+	}
+	return key
+}
+
+// Reads and decodes a value, returning false if there is no such key.
+func boltGet(tx *bolt.Tx, bucket []byte, key []byte, v any) (bool, error) {
+	value := tx.Bucket(bucket).Get(key)
+	if value == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(value, v)
+}
+
+// Encodes and writes a value, overwriting any existing value.
+func boltPut(tx *bolt.Tx, bucket []byte, key []byte, v any) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucket).Put(key, value)
+}
+
+// Encodes and writes a value, returning an error if the key already exists.
+func boltCreate(tx *bolt.Tx, bucket []byte, key []byte, v any) error {
+	if tx.Bucket(bucket).Get(key) != nil {
+		return fmt.Errorf("%s already exists", bucket)
+	}
+	return boltPut(tx, bucket, key, v)
+}
+
+// Reads a value, returning nil if there is no such key.
+func boltRead[T any](boltDatabase *BoltDatabase, bucket []byte, key []byte) (*T, error) {
+	var v T
+	var found bool
+	err := boltDatabase.db.View(func(tx *bolt.Tx) (err error) {
+		found, err = boltGet(tx, bucket, key, &v)
+		return err
+	})
+	if err != nil || !found {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Transactionally updates an existing value.
+func boltUpdate[T any](boltDatabase *BoltDatabase, bucket []byte, key []byte, transaction func(*T) error) error {
+	return boltDatabase.db.Update(func(tx *bolt.Tx) error {
+		var v T
+		found, err := boltGet(tx, bucket, key, &v)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%s not found", bucket)
+		}
+		if err := transaction(&v); err != nil {
+			return err
+		}
+		return boltPut(tx, bucket, key, &v)
+	})
+}
+
+// Deletes a key, if it exists.
+func boltDelete(boltDatabase *BoltDatabase, bucket []byte, key []byte) error {
+	return boltDatabase.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Delete(key)
+	})
+}
+
+func (boltDatabase *BoltDatabase) CreateUser(user User) error {
+	return boltDatabase.db.Update(func(tx *bolt.Tx) error {
+		return boltCreate(tx, boltUserBucket, boltKey(user.UserID), user)
+	})
+}
+
+func (boltDatabase *BoltDatabase) ReadUser(userID UserID) (*User, error) {
+	return boltRead[User](boltDatabase, boltUserBucket, boltKey(userID))
+}
+
+func (boltDatabase *BoltDatabase) UpdateUser(userID UserID, transaction func(*User) error) error {
+	return boltUpdate(boltDatabase, boltUserBucket, boltKey(userID), transaction)
+}
+
+func (boltDatabase *BoltDatabase) DeleteUser(userID UserID) error {
+	return boltDelete(boltDatabase, boltUserBucket, boltKey(userID))
+}
+
+func (boltDatabase *BoltDatabase) CreateGroup(group Group) error {
+	return boltDatabase.db.Update(func(tx *bolt.Tx) error {
+		return boltCreate(tx, boltGroupBucket, boltKey(group.GroupID), group)
+	})
+}
+
+func (boltDatabase *BoltDatabase) ReadGroup(groupID GroupID) (*Group, error) {
+	return boltRead[Group](boltDatabase, boltGroupBucket, boltKey(groupID))
+}
+
+func (boltDatabase *BoltDatabase) UpdateGroup(groupID GroupID, transaction func(*Group) error) error {
+	return boltUpdate(boltDatabase, boltGroupBucket, boltKey(groupID), transaction)
+}
+
+func (boltDatabase *BoltDatabase) ReadMessages(groupID GroupID, parentID MessageID, start MessageID, end MessageID, limit int, forward bool, system bool) ([]Message, bool, error) {
+	var messages []Message
+	more := false
+	now := time.Now()
+	err := boltDatabase.db.View(func(tx *bolt.Tx) error {
+		// Messages are keyed by group and then ID, so iterate from
+		// whichever end of the range is requested.
+		startKey := boltKey(groupID, start)
+		endKey := boltKey(groupID, end)
+		c := tx.Bucket(boltMessageBucket).Cursor()
+		var k, v []byte
+		next := c.Next
+		if forward {
+			k, v = c.Seek(startKey)
+		} else {
+			next = c.Prev
+			k, v = c.Seek(endKey)
+			if k == nil || bytes.Compare(k, endKey) > 0 {
+				k, v = c.Prev()
+			}
+		}
+		for ; k != nil && bytes.Compare(k, startKey) >= 0 && bytes.Compare(k, endKey) <= 0; k, v = next() {
+			var message Message
+			if err := json.Unmarshal(v, &message); err != nil {
+				return err
+			}
+			if message.ParentID != parentID || (!system && message.Kind != "") || message.IsExpired(now) {
+				continue
+			}
+			if len(messages) == limit {
+				more = true
+				break
+			}
+			messages = append(messages, message)
+		}
+		return nil
+	})
+	if !forward {
+		// Sort in chronological order again.
+		slices.Reverse(messages)
+	}
+	return messages, more, err
+}
+
+func (boltDatabase *BoltDatabase) DeleteGroup(groupID GroupID) error {
+	return boltDelete(boltDatabase, boltGroupBucket, boltKey(groupID))
+}
+
+func (boltDatabase *BoltDatabase) ReadGroupIDs() ([]GroupID, error) {
+	groupIDs := []GroupID{}
+	err := boltDatabase.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltGroupBucket).ForEach(func(k, _ []byte) error {
+			groupIDs = append(groupIDs, binary.BigEndian.Uint64(k))
+			return nil
+		})
+	})
+	return groupIDs, err
+}
+
+func (boltDatabase *BoltDatabase) CreateMessage(message Message) error {
+	return boltDatabase.db.Update(func(tx *bolt.Tx) error {
+		if err := boltCreate(tx, boltMessageBucket, boltKey(message.GroupID, message.MessageID), message); err != nil {
+			return err
+		}
+		return boltIndexMessage(tx, message.GroupID, message.MessageID, nil, searchTerms(message.Content))
+	})
+}
+
+// Prefixes the search keys of a term within a group, which are followed by
+// message IDs.
+func boltSearchPrefix(groupID GroupID, term string) []byte {
+	return append(append(boltKey(groupID), term...), 0)
+}
+
+// Updates the search index for a message whose terms changed.
+func boltIndexMessage(tx *bolt.Tx, groupID GroupID, messageID MessageID, oldTerms []string, newTerms []string) error {
+	bucket := tx.Bucket(boltSearchBucket)
+	for _, term := range termsWithout(oldTerms, newTerms) {
+		if err := bucket.Delete(append(boltSearchPrefix(groupID, term), boltKey(messageID)...)); err != nil {
+			return err
+		}
+	}
+	for _, term := range termsWithout(newTerms, oldTerms) {
+		if err := bucket.Put(append(boltSearchPrefix(groupID, term), boltKey(messageID)...), []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (boltDatabase *BoltDatabase) SearchMessages(groupID GroupID, search MessageSearch, limit int) ([]Message, MessageID, error) {
+	var messages []Message
+	var next MessageID
+	err := boltDatabase.db.View(func(tx *bolt.Tx) error {
+		// Every match has the first term, so check each message that does,
+		// from the end of the range.
+		prefix := boltSearchPrefix(groupID, search.Terms[0])
+		startKey := append(slices.Clip(prefix), boltKey(search.Start)...)
+		endKey := append(slices.Clip(prefix), boltKey(search.End)...)
+		c := tx.Bucket(boltSearchBucket).Cursor()
+		k, _ := c.Seek(endKey)
+		if k == nil || bytes.Compare(k, endKey) > 0 {
+			k, _ = c.Prev()
+		}
+		for ; k != nil && bytes.Compare(k, startKey) >= 0; k, _ = c.Prev() {
+			messageID := binary.BigEndian.Uint64(k[len(prefix):])
+			var message Message
+			found, err := boltGet(tx, boltMessageBucket, boltKey(groupID, messageID), &message)
+			if err != nil {
+				return err
+			}
+			if !found || !search.Matches(message) {
+				continue
+			}
+			if len(messages) == limit {
+				next = messageID
+				break
+			}
+			messages = append(messages, message)
+		}
+		return nil
+	})
+	return messages, next, err
+}
+
+func (boltDatabase *BoltDatabase) ReadMessage(groupID GroupID, messageID MessageID) (*Message, error) {
+	return boltRead[Message](boltDatabase, boltMessageBucket, boltKey(groupID, messageID))
+}
+
+func (boltDatabase *BoltDatabase) UpdateMessage(groupID GroupID, messageID MessageID, transaction func(*Message) error) error {
+	return boltDatabase.db.Update(func(tx *bolt.Tx) error {
+		key := boltKey(groupID, messageID)
+		var message Message
+		found, err := boltGet(tx, boltMessageBucket, key, &message)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%s not found", boltMessageBucket)
+		}
+		oldTerms := searchTerms(message.Content)
+		if err := transaction(&message); err != nil {
+			return err
+		}
+		if err := boltPut(tx, boltMessageBucket, key, &message); err != nil {
+			return err
+		}
+		return boltIndexMessage(tx, groupID, messageID, oldTerms, searchTerms(message.Content))
+	})
+}
+
+func (boltDatabase *BoltDatabase) DeleteMessages(groupID GroupID, end MessageID) (bool, error) {
+	deleted := false
+	err := boltDatabase.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		deleted, err = boltDeleteMessages(tx, boltKey(groupID), boltKey(groupID, end), func(Message) bool { return true })
+		return err
+	})
+	return deleted && err == nil, err
+}
+
+func (boltDatabase *BoltDatabase) UpdateMessageExpiry(groupID GroupID, expiry func(timestamp UnixMillis) int64) error {
+	now := time.Now()
+	return boltDatabase.db.Update(func(tx *bolt.Tx) error {
+		// Writing while iterating may skip keys, so collect them first.
+		var messages []Message
+		prefix := boltKey(groupID)
+		c := tx.Bucket(boltMessageBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var message Message
+			if err := json.Unmarshal(v, &message); err != nil {
+				return err
+			}
+			if !message.IsExpired(now) {
+				messages = append(messages, message)
+			}
+		}
+		for _, message := range messages {
+			message.Expiry = expiry(message.Timestamp)
+			if err := boltPut(tx, boltMessageBucket, boltKey(groupID, message.MessageID), &message); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (boltDatabase *BoltDatabase) DeleteExpiredMessages(now time.Time) error {
+	return boltDatabase.db.Update(func(tx *bolt.Tx) error {
+		_, err := boltDeleteMessages(tx, nil, boltKey(math.MaxUint64, math.MaxUint64), func(message Message) bool {
+			return message.IsExpired(now)
+		})
+		return err
+	})
+}
+
+// Deletes the messages with keys from `start` to `end` (inclusive) for which
+// `del` returns true, and their search terms, returning whether there were any.
+func boltDeleteMessages(tx *bolt.Tx, start []byte, end []byte, del func(Message) bool) (bool, error) {
+	bucket := tx.Bucket(boltMessageBucket)
+	// Deleting while iterating may skip keys, so collect them first.
+	var messages []Message
+	c := bucket.Cursor()
+	for k, v := c.Seek(start); k != nil && bytes.Compare(k, end) <= 0; k, v = c.Next() {
+		var message Message
+		if err := json.Unmarshal(v, &message); err != nil {
+			return false, err
+		}
+		if del(message) {
+			messages = append(messages, message)
+		}
+	}
+	for _, message := range messages {
+		if err := bucket.Delete(boltKey(message.GroupID, message.MessageID)); err != nil {
+			return false, err
+		}
+		if err := boltIndexMessage(tx, message.GroupID, message.MessageID, searchTerms(message.Content), nil); err != nil {
+			return false, err
+		}
+	}
+	return len(messages) > 0, nil
+}
+
+func (boltDatabase *BoltDatabase) ReadConnection(connectionID ConnectionID) (*UserID, error) {
+	return boltRead[UserID](boltDatabase, boltConnectionBucket, []byte(connectionID))
+}
+
+func (boltDatabase *BoltDatabase) WriteConnection(connectionID ConnectionID, userID UserID) error {
+	return boltDatabase.db.Update(func(tx *bolt.Tx) error {
+		return boltPut(tx, boltConnectionBucket, []byte(connectionID), userID)
+	})
+}
+
+func (boltDatabase *BoltDatabase) DeleteConnection(connectionID ConnectionID) error {
+	return boltDelete(boltDatabase, boltConnectionBucket, []byte(connectionID))
+}
+
+func (boltDatabase *BoltDatabase) CreateSession(session Session) error {
+	return boltDatabase.db.Update(func(tx *bolt.Tx) error {
+		return boltCreate(tx, boltSessionBucket, []byte(session.SessionID), session)
+	})
+}
+
+func (boltDatabase *BoltDatabase) ReadSession(sessionID SessionID) (*Session, error) {
+	return boltRead[Session](boltDatabase, boltSessionBucket, []byte(sessionID))
+}
+
+func (boltDatabase *BoltDatabase) DeleteSession(sessionID SessionID) error {
+	return boltDelete(boltDatabase, boltSessionBucket, []byte(sessionID))
+}
+
+func (boltDatabase *BoltDatabase) WriteJob(job Job) error {
+	return boltDatabase.db.Update(func(tx *bolt.Tx) error {
+		return boltPut(tx, boltJobBucket, []byte(job.JobID), job)
+	})
+}
+
+func (boltDatabase *BoltDatabase) ReadJob(jobID JobID) (*Job, error) {
+	return boltRead[Job](boltDatabase, boltJobBucket, []byte(jobID))
+}
+
+func (boltDatabase *BoltDatabase) ReadJobs() ([]Job, error) {
+	jobs := []Job{}
+	err := boltDatabase.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltJobBucket).ForEach(func(_, v []byte) error {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+	})
+	return jobs, err
+}
+
+func (boltDatabase *BoltDatabase) DeleteJob(jobID JobID) error {
+	return boltDelete(boltDatabase, boltJobBucket, []byte(jobID))
+}
+
+func (boltDatabase *BoltDatabase) ReadVariable(name string) (string, error) {
+	value, err := boltRead[string](boltDatabase, boltVariableBucket, []byte(name))
+	if err != nil || value == nil {
+		return "", err
+	}
+	return *value, nil
+}
+
+func (boltDatabase *BoltDatabase) WriteVariable(name string, value string) error {
+	return boltDatabase.db.Update(func(tx *bolt.Tx) error {
+		return boltCreate(tx, boltVariableBucket, []byte(name), value)
+	})
+}
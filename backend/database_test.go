@@ -1,8 +1,15 @@
 package main
 
 import (
+	"cmp"
+	"fmt"
+	"math"
 	"os"
+	"path/filepath"
+	"slices"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -189,3 +196,518 @@ func TestReadGroup(t *testing.T) {
 
 	assert.Equal(t, "Portland", group.Name)
 }
+
+// Test that the file-backed database survives a restart.
+func TestBoltDatabaseRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	table, err := NewBoltDatabase(path)
+	assert.Nil(t, err)
+
+	var userID UserID = GenerateID()
+	var groupID GroupID = GenerateID()
+	assert.Nil(t, table.CreateUser(User{UserID: userID, Name: "Bob"}))
+	assert.Nil(t, table.CreateGroup(Group{GroupID: groupID, Name: "Portland"}))
+	assert.Nil(t, table.CreateMessage(Message{GroupID: groupID, MessageID: 1, Content: "hi"}))
+	assert.Nil(t, table.WriteConnection("abcd", userID))
+
+	// Test: data survives a restart, except connections.
+	assert.Nil(t, table.Close())
+	table, err = NewBoltDatabase(path)
+	assert.Nil(t, err)
+	defer table.Close()
+
+	user, err := table.ReadUser(userID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Bob", user.Name)
+	group, err := table.ReadGroup(groupID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Portland", group.Name)
+	messages, _, err := table.ReadMessages(groupID, 0, 0, math.MaxUint64, 100, false, true)
+	assert.Nil(t, err)
+	assert.Len(t, messages, 1)
+	connection, err := table.ReadConnection("abcd")
+	assert.Nil(t, err)
+	assert.Nil(t, connection)
+}
+
+// Runs the conformance suite against every implementation of `Database`.
+func TestDatabaseConformance(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		testDatabaseConformance(t, NewMemoryDatabase())
+	})
+	t.Run("bolt", func(t *testing.T) {
+		database, err := NewBoltDatabase(filepath.Join(t.TempDir(), "test.db"))
+		assert.Nil(t, err)
+		defer database.Close()
+		testDatabaseConformance(t, database)
+	})
+	t.Run("dynamo", func(t *testing.T) {
+		maybeSkip(t)
+		testDatabaseConformance(t, NewDynamoDB(nil))
+	})
+}
+
+// Checks that a database behaves as documented by the `Database` interface.
+//
+// Only uses new, random keys, so that it can run against a database that
+// already contains data.
+func testDatabaseConformance(t *testing.T, database Database) {
+	t.Run("user", func(t *testing.T) {
+		userID := GenerateID()
+		user, err := database.ReadUser(userID)
+		assert.Nil(t, err)
+		assert.Nil(t, user)
+		assert.NotNil(t, database.UpdateUser(userID, func(user *User) error { return nil }))
+
+		assert.Nil(t, database.CreateUser(User{UserID: userID, Name: "Bob"}))
+		assert.NotNil(t, database.CreateUser(User{UserID: userID, Name: "Alice"}))
+		user, err = database.ReadUser(userID)
+		assert.Nil(t, err)
+		assert.Equal(t, "Bob", user.Name)
+
+		// Test: a failed transaction has no effect.
+		assert.NotNil(t, database.UpdateUser(userID, func(user *User) error {
+			user.Name = "Alice"
+			return fmt.Errorf("oops")
+		}))
+		user, err = database.ReadUser(userID)
+		assert.Nil(t, err)
+		assert.Equal(t, "Bob", user.Name)
+
+		assert.Nil(t, database.UpdateUser(userID, func(user *User) error {
+			user.Status = "lit"
+			return nil
+		}))
+		user, err = database.ReadUser(userID)
+		assert.Nil(t, err)
+		assert.Equal(t, "Bob", user.Name)
+		assert.Equal(t, "lit", user.Status)
+
+		assert.Nil(t, database.DeleteUser(userID))
+		user, err = database.ReadUser(userID)
+		assert.Nil(t, err)
+		assert.Nil(t, user)
+		assert.Nil(t, database.DeleteUser(userID))
+	})
+
+	t.Run("group", func(t *testing.T) {
+		groupID := GenerateID()
+		group, err := database.ReadGroup(groupID)
+		assert.Nil(t, err)
+		assert.Nil(t, group)
+		assert.NotNil(t, database.UpdateGroup(groupID, func(group *Group) error { return nil }))
+
+		assert.Nil(t, database.CreateGroup(Group{GroupID: groupID, Name: "Portland"}))
+		assert.NotNil(t, database.CreateGroup(Group{GroupID: groupID, Name: "Seattle"}))
+
+		assert.NotNil(t, database.UpdateGroup(groupID, func(group *Group) error {
+			group.Name = "Seattle"
+			return fmt.Errorf("oops")
+		}))
+		group, err = database.ReadGroup(groupID)
+		assert.Nil(t, err)
+		assert.Equal(t, "Portland", group.Name)
+
+		// Test: concurrent transactions don't overwrite each other.
+		const writers = 8
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.Nil(t, database.UpdateGroup(groupID, func(group *Group) error {
+					group.Members = append(group.Members, GenerateID())
+					return nil
+				}))
+			}()
+		}
+		wg.Wait()
+		group, err = database.ReadGroup(groupID)
+		assert.Nil(t, err)
+		assert.Len(t, group.Members, writers)
+		groupIDs, err := database.ReadGroupIDs()
+		assert.Nil(t, err)
+		assert.Contains(t, groupIDs, groupID)
+
+		assert.Nil(t, database.DeleteGroup(groupID))
+		group, err = database.ReadGroup(groupID)
+		assert.Nil(t, err)
+		assert.Nil(t, group)
+		groupIDs, err = database.ReadGroupIDs()
+		assert.Nil(t, err)
+		assert.NotContains(t, groupIDs, groupID)
+		assert.Nil(t, database.DeleteGroup(groupID))
+	})
+
+	t.Run("message", func(t *testing.T) {
+		groupID := GenerateID()
+		otherGroupID := GenerateID()
+		messages, more, err := database.ReadMessages(groupID, 0, 0, math.MaxUint64, 100, false, true)
+		assert.Nil(t, err)
+		assert.False(t, more)
+		assert.Empty(t, messages)
+
+		const count = 12
+		for messageID := MessageID(1); messageID <= count; messageID++ {
+			assert.Nil(t, database.CreateMessage(Message{GroupID: groupID, MessageID: messageID, Content: fmt.Sprint(messageID)}))
+		}
+		assert.NotNil(t, database.CreateMessage(Message{GroupID: groupID, MessageID: 1}))
+		assert.Nil(t, database.CreateMessage(Message{GroupID: otherGroupID, MessageID: 1}))
+
+		// Test: bounds are inclusive.
+		messages, more, err = database.ReadMessages(groupID, 0, 3, 4, 100, false, true)
+		assert.Nil(t, err)
+		assert.False(t, more)
+		assert.Len(t, messages, 2)
+		assert.Equal(t, MessageID(3), messages[0].MessageID)
+		assert.Equal(t, "4", messages[1].Content)
+
+		// Test: a page that exactly exhausts the range has nothing more.
+		messages, more, err = database.ReadMessages(groupID, 0, 3, 4, 2, true, true)
+		assert.Nil(t, err)
+		assert.False(t, more)
+		assert.Len(t, messages, 2)
+
+		// Test: reading from either end of the range.
+		messages, more, err = database.ReadMessages(groupID, 0, 3, 10, 2, true, true)
+		assert.Nil(t, err)
+		assert.True(t, more)
+		assert.Equal(t, []MessageID{3, 4}, messageIDs(messages))
+		messages, more, err = database.ReadMessages(groupID, 0, 3, 10, 2, false, true)
+		assert.Nil(t, err)
+		assert.True(t, more)
+		assert.Equal(t, []MessageID{9, 10}, messageIDs(messages))
+
+		// Test: paging in either direction visits every message once.
+		for _, forward := range []bool{false, true} {
+			var all []Message
+			start, end := MessageID(0), MessageID(math.MaxUint64)
+			for pages := 0; pages < count; pages++ {
+				messages, more, err := database.ReadMessages(groupID, 0, start, end, 5, forward, true)
+				assert.Nil(t, err)
+				assert.LessOrEqual(t, len(messages), 5)
+				assert.True(t, slices.IsSortedFunc(messages, func(a, b Message) int {
+					return cmp.Compare(a.MessageID, b.MessageID)
+				}))
+				if forward {
+					all = append(all, messages...)
+				} else {
+					all = append(messages, all...)
+				}
+				if !more {
+					break
+				}
+				if forward {
+					start = messages[len(messages)-1].MessageID + 1
+				} else {
+					end = messages[0].MessageID - 1
+				}
+			}
+			assert.Len(t, all, count)
+			for i, message := range all {
+				assert.Equal(t, MessageID(i+1), message.MessageID)
+				assert.Equal(t, groupID, message.GroupID)
+			}
+		}
+
+		// Test: replies are read separately from their thread's parent.
+		const replies = 3
+		for messageID := MessageID(count + 1); messageID <= count+replies; messageID++ {
+			assert.Nil(t, database.CreateMessage(Message{GroupID: groupID, MessageID: messageID, ParentID: 2}))
+		}
+		messages, more, err = database.ReadMessages(groupID, 2, 0, math.MaxUint64, 2, true, true)
+		assert.Nil(t, err)
+		assert.True(t, more)
+		assert.Equal(t, []MessageID{count + 1, count + 2}, messageIDs(messages))
+		messages, more, err = database.ReadMessages(groupID, 0, count, math.MaxUint64, 100, true, true)
+		assert.Nil(t, err)
+		assert.False(t, more)
+		assert.Equal(t, []MessageID{count}, messageIDs(messages))
+
+		// Test: system messages can be skipped.
+		systemID := MessageID(count + replies + 1)
+		assert.Nil(t, database.CreateMessage(Message{GroupID: groupID, MessageID: systemID, Kind: MessageKindMemberJoined}))
+		messages, _, err = database.ReadMessages(groupID, 0, count, math.MaxUint64, 100, true, true)
+		assert.Nil(t, err)
+		assert.Equal(t, []MessageID{count, systemID}, messageIDs(messages))
+		assert.Equal(t, MessageKindMemberJoined, messages[1].Kind)
+		messages, more, err = database.ReadMessages(groupID, 0, count-1, math.MaxUint64, 1, false, false)
+		assert.Nil(t, err)
+		assert.True(t, more)
+		assert.Equal(t, []MessageID{count}, messageIDs(messages))
+
+		// Test: reading and updating a single message.
+		message, err := database.ReadMessage(groupID, 5)
+		assert.Nil(t, err)
+		assert.NotNil(t, message)
+		assert.Equal(t, "5", message.Content)
+		assert.Nil(t, database.UpdateMessage(groupID, 5, func(message *Message) error {
+			message.Content = "five"
+			message.EditedAt = 1
+			return nil
+		}))
+		message, err = database.ReadMessage(groupID, 5)
+		assert.Nil(t, err)
+		assert.Equal(t, "five", message.Content)
+		assert.Equal(t, UnixMillis(1), message.EditedAt)
+		assert.NotNil(t, database.UpdateMessage(groupID, 5, func(message *Message) error {
+			message.Content = "discarded"
+			return fmt.Errorf("oops")
+		}))
+		message, err = database.ReadMessage(groupID, 5)
+		assert.Nil(t, err)
+		assert.Equal(t, "five", message.Content)
+		message, err = database.ReadMessage(groupID, systemID+1)
+		assert.Nil(t, err)
+		assert.Nil(t, message)
+		assert.NotNil(t, database.UpdateMessage(groupID, systemID+1, func(message *Message) error { return nil }))
+
+		deleted, err := database.DeleteMessages(groupID, 5)
+		assert.Nil(t, err)
+		assert.True(t, deleted)
+		deleted, err = database.DeleteMessages(groupID, 5)
+		assert.Nil(t, err)
+		assert.False(t, deleted)
+		messages, _, err = database.ReadMessages(groupID, 0, 0, math.MaxUint64, 100, true, true)
+		assert.Nil(t, err)
+		assert.NotEmpty(t, messages)
+		assert.Greater(t, messages[0].MessageID, MessageID(5))
+
+		deleted, err = database.DeleteMessages(groupID, math.MaxUint64)
+		assert.Nil(t, err)
+		assert.True(t, deleted)
+		messages, more, err = database.ReadMessages(groupID, 0, 0, math.MaxUint64, 100, false, true)
+		assert.Nil(t, err)
+		assert.False(t, more)
+		assert.Empty(t, messages)
+		messages, _, err = database.ReadMessages(otherGroupID, 0, 0, math.MaxUint64, 100, false, true)
+		assert.Nil(t, err)
+		assert.Len(t, messages, 1)
+		_, err = database.DeleteMessages(groupID, math.MaxUint64)
+		assert.Nil(t, err)
+	})
+
+	t.Run("search", func(t *testing.T) {
+		groupID := GenerateID()
+		otherGroupID := GenerateID()
+		search := func(sender UserID, start MessageID, end MessageID, limit int, terms ...string) ([]MessageID, MessageID) {
+			messages, next, err := database.SearchMessages(groupID, MessageSearch{Terms: terms, Sender: sender, Start: start, End: end}, limit)
+			assert.Nil(t, err)
+			return messageIDs(messages), next
+		}
+
+		assert.Nil(t, database.CreateMessage(Message{GroupID: groupID, MessageID: 1, Sender: 1, Content: "Dinner at the beach?"}))
+		assert.Nil(t, database.CreateMessage(Message{GroupID: groupID, MessageID: 2, Sender: 2, Content: "beach, beach, BEACH!"}))
+		assert.Nil(t, database.CreateMessage(Message{GroupID: groupID, MessageID: 3, Sender: 1, Content: "dinner's at 7", ParentID: 1}))
+		assert.Nil(t, database.CreateMessage(Message{GroupID: groupID, MessageID: 4, Sender: 2, Content: "no"}))
+		assert.Nil(t, database.CreateMessage(Message{GroupID: otherGroupID, MessageID: 5, Content: "beach"}))
+
+		// Test: matches are latest first and include replies.
+		ids, next := search(0, 0, math.MaxUint64, 100, "dinner")
+		assert.Equal(t, []MessageID{3, 1}, ids)
+		assert.Zero(t, next)
+		ids, _ = search(0, 0, math.MaxUint64, 100, "beach")
+		assert.Equal(t, []MessageID{2, 1}, ids)
+
+		// Test: every term must match.
+		ids, _ = search(0, 0, math.MaxUint64, 100, "beach", "dinner")
+		assert.Equal(t, []MessageID{1}, ids)
+		ids, _ = search(0, 0, math.MaxUint64, 100, "beach", "lunch")
+		assert.Empty(t, ids)
+
+		// Test: filtering by sender and range.
+		ids, _ = search(2, 0, math.MaxUint64, 100, "beach")
+		assert.Equal(t, []MessageID{2}, ids)
+		ids, _ = search(0, 2, 3, 100, "dinner", "at")
+		assert.Equal(t, []MessageID{3}, ids)
+
+		// Test: limit.
+		ids, next = search(0, 0, math.MaxUint64, 1, "at")
+		assert.Equal(t, []MessageID{3}, ids)
+		assert.Equal(t, MessageID(1), next)
+		ids, next = search(0, 0, 2, 1, "at")
+		assert.Equal(t, []MessageID{1}, ids)
+		assert.Zero(t, next)
+
+		// Test: the index follows edits and deletions.
+		assert.Nil(t, database.UpdateMessage(groupID, 2, func(message *Message) error {
+			message.Content = "dinner"
+			return nil
+		}))
+		ids, _ = search(0, 0, math.MaxUint64, 100, "beach")
+		assert.Equal(t, []MessageID{1}, ids)
+		ids, _ = search(0, 0, math.MaxUint64, 100, "dinner")
+		assert.Equal(t, []MessageID{3, 2, 1}, ids)
+		assert.Nil(t, database.UpdateMessage(groupID, 1, func(message *Message) error {
+			message.Content = ""
+			message.Deleted = true
+			return nil
+		}))
+		ids, _ = search(0, 0, math.MaxUint64, 100, "dinner")
+		assert.Equal(t, []MessageID{3, 2}, ids)
+
+		_, err := database.DeleteMessages(groupID, math.MaxUint64)
+		assert.Nil(t, err)
+		ids, _ = search(0, 0, math.MaxUint64, 100, "dinner")
+		assert.Empty(t, ids)
+		messages, _, err := database.SearchMessages(otherGroupID, MessageSearch{Terms: []string{"beach"}, End: math.MaxUint64}, 100)
+		assert.Nil(t, err)
+		assert.Len(t, messages, 1)
+		_, err = database.DeleteMessages(otherGroupID, math.MaxUint64)
+		assert.Nil(t, err)
+	})
+
+	t.Run("retention", func(t *testing.T) {
+		groupID := GenerateID()
+		now := time.Now()
+		expired := now.Add(-time.Hour).Unix()
+		live := now.Add(time.Hour).Unix()
+		assert.Nil(t, database.CreateMessage(Message{GroupID: groupID, MessageID: 1, Content: "old news", Expiry: expired}))
+		assert.Nil(t, database.CreateMessage(Message{GroupID: groupID, MessageID: 2, Content: "fresh news", Expiry: live}))
+		assert.Nil(t, database.CreateMessage(Message{GroupID: groupID, MessageID: 3, Content: "forever news"}))
+
+		// Test: expired messages are hidden, even before they are deleted.
+		messages, _, err := database.ReadMessages(groupID, 0, 0, math.MaxUint64, 100, true, true)
+		assert.Nil(t, err)
+		assert.Equal(t, []MessageID{2, 3}, messageIDs(messages))
+		results, _, err := database.SearchMessages(groupID, MessageSearch{Terms: []string{"news"}, End: math.MaxUint64}, 100)
+		assert.Nil(t, err)
+		assert.Equal(t, []MessageID{3, 2}, messageIDs(results))
+
+		assert.Nil(t, database.DeleteExpiredMessages(now))
+		messages, _, err = database.ReadMessages(groupID, 0, 0, math.MaxUint64, 100, true, true)
+		assert.Nil(t, err)
+		assert.Equal(t, []MessageID{2, 3}, messageIDs(messages))
+
+		// Test: later, the rest expire, except those kept forever.
+		assert.Nil(t, database.DeleteExpiredMessages(now.Add(2*time.Hour)))
+		messages, _, err = database.ReadMessages(groupID, 0, 0, math.MaxUint64, 100, true, true)
+		assert.Nil(t, err)
+		if _, ok := database.(*DynamoDB); !ok {
+			assert.Equal(t, []MessageID{3}, messageIDs(messages))
+		}
+
+		_, err = database.DeleteMessages(groupID, math.MaxUint64)
+		assert.Nil(t, err)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		groupID := GenerateID()
+		now := time.Now()
+		assert.Nil(t, database.CreateMessage(Message{GroupID: groupID, MessageID: 1, Timestamp: 1, Content: "old news", Expiry: now.Add(-time.Hour).Unix()}))
+		assert.Nil(t, database.CreateMessage(Message{GroupID: groupID, MessageID: 2, Timestamp: 2, Content: "fresh news", Expiry: now.Add(time.Hour).Unix()}))
+		assert.Nil(t, database.CreateMessage(Message{GroupID: groupID, MessageID: 3, Timestamp: 3, Content: "forever news"}))
+
+		// Test: messages that haven't expired get the new expiry, and the rest
+		// stay expired.
+		assert.Nil(t, database.UpdateMessageExpiry(groupID, func(timestamp UnixMillis) int64 {
+			if timestamp == 3 {
+				return now.Add(time.Hour).Unix()
+			}
+			return 0
+		}))
+		messages, _, err := database.ReadMessages(groupID, 0, 0, math.MaxUint64, 100, true, true)
+		assert.Nil(t, err)
+		assert.Equal(t, []MessageID{2, 3}, messageIDs(messages))
+		assert.Zero(t, messages[0].Expiry)
+		assert.Equal(t, now.Add(time.Hour).Unix(), messages[1].Expiry)
+		results, _, err := database.SearchMessages(groupID, MessageSearch{Terms: []string{"news"}, End: math.MaxUint64}, 100)
+		assert.Nil(t, err)
+		assert.Equal(t, []MessageID{3, 2}, messageIDs(results))
+
+		if _, ok := database.(*DynamoDB); !ok {
+			assert.Nil(t, database.DeleteExpiredMessages(now.Add(2*time.Hour)))
+			messages, _, err = database.ReadMessages(groupID, 0, 0, math.MaxUint64, 100, true, true)
+			assert.Nil(t, err)
+			assert.Equal(t, []MessageID{2}, messageIDs(messages))
+		}
+
+		_, err = database.DeleteMessages(groupID, math.MaxUint64)
+		assert.Nil(t, err)
+	})
+
+	t.Run("connection", func(t *testing.T) {
+		connectionID := fmt.Sprint(GenerateID())
+		userID, err := database.ReadConnection(connectionID)
+		assert.Nil(t, err)
+		assert.Nil(t, userID)
+
+		assert.Nil(t, database.WriteConnection(connectionID, 1))
+		assert.Nil(t, database.WriteConnection(connectionID, 2))
+		userID, err = database.ReadConnection(connectionID)
+		assert.Nil(t, err)
+		assert.Equal(t, UserID(2), *userID)
+
+		assert.Nil(t, database.DeleteConnection(connectionID))
+		userID, err = database.ReadConnection(connectionID)
+		assert.Nil(t, err)
+		assert.Nil(t, userID)
+		assert.Nil(t, database.DeleteConnection(connectionID))
+	})
+
+	t.Run("session", func(t *testing.T) {
+		sessionID := fmt.Sprint(GenerateID())
+		session, err := database.ReadSession(sessionID)
+		assert.Nil(t, err)
+		assert.Nil(t, session)
+
+		assert.Nil(t, database.CreateSession(Session{SessionID: sessionID, UserID: 1}))
+		assert.NotNil(t, database.CreateSession(Session{SessionID: sessionID, UserID: 2}))
+		session, err = database.ReadSession(sessionID)
+		assert.Nil(t, err)
+		assert.Equal(t, UserID(1), session.UserID)
+
+		assert.Nil(t, database.DeleteSession(sessionID))
+		session, err = database.ReadSession(sessionID)
+		assert.Nil(t, err)
+		assert.Nil(t, session)
+		assert.Nil(t, database.DeleteSession(sessionID))
+	})
+
+	t.Run("job", func(t *testing.T) {
+		jobID := fmt.Sprint(GenerateID())
+		job, err := database.ReadJob(jobID)
+		assert.Nil(t, err)
+		assert.Nil(t, job)
+
+		assert.Nil(t, database.WriteJob(Job{JobID: jobID, Date: 1}))
+		assert.Nil(t, database.WriteJob(Job{JobID: jobID, Date: 2}))
+		job, err = database.ReadJob(jobID)
+		assert.Nil(t, err)
+		assert.Equal(t, UnixMillis(2), job.Date)
+		jobs, err := database.ReadJobs()
+		assert.Nil(t, err)
+		assert.True(t, slices.ContainsFunc(jobs, func(job Job) bool { return job.JobID == jobID }))
+
+		assert.Nil(t, database.DeleteJob(jobID))
+		job, err = database.ReadJob(jobID)
+		assert.Nil(t, err)
+		assert.Nil(t, job)
+		jobs, err = database.ReadJobs()
+		assert.Nil(t, err)
+		assert.False(t, slices.ContainsFunc(jobs, func(job Job) bool { return job.JobID == jobID }))
+		assert.Nil(t, database.DeleteJob(jobID))
+	})
+
+	t.Run("variable", func(t *testing.T) {
+		name := fmt.Sprint(GenerateID())
+		value, err := database.ReadVariable(name)
+		assert.Nil(t, err)
+		assert.Empty(t, value)
+
+		assert.Nil(t, database.WriteVariable(name, "first"))
+		assert.NotNil(t, database.WriteVariable(name, "second"))
+		value, err = database.ReadVariable(name)
+		assert.Nil(t, err)
+		assert.Equal(t, "first", value)
+	})
+}
+
+// Helper to list the IDs of messages.
+func messageIDs(messages []Message) []MessageID {
+	messageIDs := []MessageID{}
+	for _, message := range messages {
+		messageIDs = append(messageIDs, message.MessageID)
+	}
+	return messageIDs
+}
@@ -9,17 +9,41 @@ type GroupID = uint64
 type ActivityID = uint64
 type AvailabilityID = uint64
 type TaskID = uint64
+type PollID = uint64
+type AnnouncementID = uint64
 type UnixMillis = uint64
+type SessionID = string
+
+// Identifies a chat message within its group. Sorts in the order messages were
+// sent (see `newMessageID`).
+type MessageID = uint64
 
 type Group struct {
-	GroupID        GroupID `dynamo:",hash"` // Hash key, a.k.a. partition key
-	Name           string
-	CalendarMode   string
-	Poll           *Poll
+	GroupID      GroupID `dynamo:",hash"` // Hash key, a.k.a. partition key
+	Name         string
+	CalendarMode string
+	// The poll from before groups could have several, which is moved to
+	// `Polls` when the group's polls are next changed (see
+	// `Group.migratePoll`).
+	Poll *Poll
+	// Polls in the order they were created, including closed and archived
+	// ones.
+	Polls          []Poll
 	Members        []UserID
+	Owner          UserID
+	Admins         []UserID `dynamo:",set"`
+	Banned         []UserID `dynamo:",set"`
 	Activities     []Activity
 	Availabilities []Availability
 	Tasks          []Task
+	Invites        []Invite
+	// Days that chat messages are kept, or 0 to keep them forever.
+	RetentionDays uint64
+	// Chat messages pinned by members, in the order they were pinned.
+	Pins          []Pin
+	Announcements []Announcement
+	// Unix millisecond time when the group was archived, or 0 if it is active.
+	Archived UnixMillis
 	// Counts updates to help ensure atomicity.
 	UpdateCount uint64
 }
@@ -31,22 +55,68 @@ type User struct {
 	Groups        []GroupID      `dynamo:",set"`
 	Connections   []ConnectionID `dynamo:",set"`
 	Subscriptions []webpush.Subscription
+	// Whether to only push chat messages that mention the user.
+	PushMentionsOnly bool
+	// How far the user has read the chat of each group.
+	ReadPositions []ReadPosition
 	// Counts updates to help ensure atomicity.
 	UpdateCount uint64
 }
 
 type Poll struct {
+	PollID    PollID
+	Creator   UserID
 	Title     string
 	Timestamp uint64
 	Options   []PollOption
-	DoneFlag  bool
+	// Whether the poll was closed, so that votes can no longer change.
+	Closed bool
+	// Whether the poll was deleted (or made room for another), which also
+	// closes it.
+	Archived bool
 }
 
 type Message struct {
-	GroupID   GroupID `dynamo:",hash"`
-	Timestamp uint64  `dynamo:",range"`
+	GroupID   GroupID   `dynamo:",hash"`
+	MessageID MessageID `dynamo:",range" index:"Thread-index,range"`
+	Timestamp uint64
 	Content   string
 	Sender    UserID
+	// The kind of system message (like `MessageKindPollCreated`), or empty if a
+	// member wrote the message. For system messages, the sender is the member
+	// that caused the event, and the content names its subject (if any).
+	Kind string
+	// The message this is a reply to, or 0 if it is not in a thread.
+	ParentID MessageID
+	// The group and thread, like "1234/5678" (or "1234/0" outside any thread),
+	// so that DynamoDB can read a thread without the group's other messages.
+	// Only DynamoDB sets this (see `messageThread`).
+	Thread string `dynamo:",omitempty" index:"Thread-index,hash"`
+	// Replies to this message, and the Unix millisecond time of the latest.
+	ReplyCount uint64
+	LastReply  UnixMillis
+	// Unix millisecond time when the message was last edited, or 0 if never.
+	EditedAt UnixMillis
+	// Whether the message was deleted, leaving only a tombstone (with no content).
+	Deleted bool
+	// Members mentioned in the message.
+	Mentions []UserID `dynamo:",set"`
+	// Members that reacted to the message, by emoji.
+	Reactions map[string][]UserID
+	// Unix seconds, so DynamoDB can expire the message automatically, or 0 if
+	// it is kept forever.
+	Expiry int64 `dynamo:",omitempty"`
+	// Counts updates to help ensure atomicity.
+	UpdateCount uint64
+}
+
+// Records that a chat message contains a search term.
+type MessageIndexEntry struct {
+	// The group ID and term, like "1234/airbnb".
+	Term      string    `dynamo:",hash"`
+	MessageID MessageID `dynamo:",range"`
+	// Expires with the message (see `Message.Expiry`).
+	Expiry int64 `dynamo:",omitempty"`
 }
 
 type Variable struct {
@@ -54,11 +124,33 @@ type Variable struct {
 	Value string
 }
 
+type Session struct {
+	SessionID SessionID `dynamo:",hash"`
+	UserID    UserID
+	Created   UnixMillis
+	// Unix seconds, so DynamoDB can expire the session automatically.
+	Expiry int64
+}
+
+type Job struct {
+	JobID      JobID `dynamo:",hash"`
+	Date       UnixMillis
+	Activation Activation
+	// Unix seconds, so DynamoDB can forget the job automatically.
+	Expiry int64
+}
+
 type Connection struct {
 	ConnectionID ConnectionID `dynamo:",hash"`
 	UserID       UserID
 }
 
+type ReadPosition struct {
+	GroupID GroupID
+	// The latest message read.
+	MessageID MessageID
+}
+
 type PollOption struct {
 	Name  string
 	Votes []UserID `dynamo:",set"`
@@ -66,11 +158,16 @@ type PollOption struct {
 
 type Activity struct {
 	ActivityID ActivityID
+	Creator    UserID
 	Title      string
 	Date       string
 	Start      string
 	End        string
 	Confirmed  []UserID `dynamo:",set"`
+	// Members that have been reminded of the activity.
+	Reminded []UserID `dynamo:",set"`
+	// The pending reminder job, if any.
+	ReminderJob JobID
 }
 
 type Availability struct {
@@ -81,9 +178,42 @@ type Availability struct {
 	End            string
 }
 
+type Invite struct {
+	Token   string
+	Creator UserID
+	// Zero means never expires.
+	Expiry UnixMillis
+	// Zero means unlimited.
+	MaxUses uint64
+	Uses    uint64
+}
+
 type Task struct {
 	TaskID    TaskID
 	Title     string
 	Assignee  UserID
 	Completed bool
+	// Date like "2006-01-02", or empty if there is no due date.
+	Due string
+	// Whether the assignee has been reminded of the due date.
+	Reminded bool
+	// The pending reminder job, if any.
+	ReminderJob JobID
+}
+
+type Pin struct {
+	MessageID MessageID
+	Pinner    UserID
+	Timestamp UnixMillis
+}
+
+type Announcement struct {
+	AnnouncementID AnnouncementID
+	Creator        UserID
+	Content        string
+	Timestamp      UnixMillis
+	// Unix millisecond time when the announcement expires, or 0 if never.
+	Expiry UnixMillis
+	// The pending expiry job, if any.
+	ExpiryJob JobID
 }
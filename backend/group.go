@@ -3,8 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"log"
+	"math"
 	"net/http"
 	"slices"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -13,23 +17,54 @@ const (
 	groupNameMinLen  = 1
 	groupNameMaxLen  = 50
 	maxGroupsPerUser = 64
+	// How long an abandoned group is archived before it is deleted.
+	groupDeletionDelay = 7 * 24 * time.Hour
 )
 
 // Group sent over JSON.
 type GetGroupResponse struct {
 	Name           string                         `json:"name"`
 	Members        []UserID                       `json:"members"`
-	Poll           *GetGroupResponsePoll          `json:"poll"`
+	Owner          UserID                         `json:"owner"`
+	Admins         []UserID                       `json:"admins"`
+	Polls          []GetGroupResponsePoll         `json:"polls"`
 	Availabilities []GetGroupResponseAvailability `json:"availabilities"`
 	Activities     []GetGroupResponseActivity     `json:"activities"`
 	Tasks          []GetGroupResponseTask         `json:"tasks"`
 	CalendarMode   string                         `json:"calendarMode"`
+	Pins           []GetGroupResponsePin          `json:"pins"`
+	Announcements  []GetGroupResponseAnnouncement `json:"announcements"`
+	// Days that chat messages are kept, or 0 to keep them forever.
+	RetentionDays uint64 `json:"retentionDays"`
+}
+
+// Pinned chat message sent over JSON.
+type GetGroupResponsePin struct {
+	MessageID MessageID `json:"messageId"`
+	Pinner    UserID    `json:"pinner"`
+	Timestamp uint64    `json:"timestamp"`
+	Sender    UserID    `json:"sender"`
+	Content   string    `json:"content"`
+}
+
+// Announcement sent over JSON.
+type GetGroupResponseAnnouncement struct {
+	AnnouncementID AnnouncementID `json:"announcementId"`
+	Creator        UserID         `json:"creator"`
+	Content        string         `json:"content"`
+	Timestamp      uint64         `json:"timestamp"`
+	Expiry         uint64         `json:"expiry,omitempty"`
 }
 
 // Poll sent over JSON.
 type GetGroupResponsePoll struct {
-	Title   string                       `json:"title"`
-	Options []GetGroupResponsePollOption `json:"options"`
+	PollID    PollID                       `json:"pollId"`
+	Creator   UserID                       `json:"creator"`
+	Title     string                       `json:"title"`
+	Timestamp uint64                       `json:"timestamp"`
+	Closed    bool                         `json:"closed"`
+	Archived  bool                         `json:"archived"`
+	Options   []GetGroupResponsePollOption `json:"options"`
 }
 
 // Poll option sent over JSON.
@@ -50,6 +85,7 @@ type GetGroupResponseAvailability struct {
 // Activity sent over JSON.
 type GetGroupResponseActivity struct {
 	ActivityID ActivityID `json:"activityId"`
+	Creator    UserID     `json:"creator"`
 	Title      string     `json:"title"`
 	Date       string     `json:"date"`
 	Start      string     `json:"start"`
@@ -63,12 +99,15 @@ type GetGroupResponseTask struct {
 	Title     string `json:"title"`
 	Assignee  UserID `json:"assignee"`
 	Completed bool   `json:"completed"`
+	Due       string `json:"due"`
 }
 
 // Group properties sent over JSON, used to create or update group.
 type PatchGroupRequest struct {
 	Name         string `json:"name"`
 	CalendarMode string `json:"calendarMode"`
+	// Days to keep chat messages, or 0 to keep them forever.
+	RetentionDays *uint64 `json:"retentionDays"`
 }
 
 // Group ID sent over JSON.
@@ -77,9 +116,9 @@ type PatchGroupResponse struct {
 }
 
 // API's related to groups.
-func RestGroupAPI(router *mux.Router, database Database, notification Notification) {
+func RestGroupAPI(router *mux.Router, database Database, notification Notification, scheduler Scheduler) {
 	router.Use(AuthenticateMiddleware(database))
-	RestSpecificGroupAPI(AddHandler(router, "/{groupID}"), database, notification)
+	RestSpecificGroupAPI(AddHandler(router, "/{groupID}"), database, notification, scheduler)
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPatch {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -101,13 +140,20 @@ func RestGroupAPI(router *mux.Router, database Database, notification Notificati
 			}
 			calendarMode = request.CalendarMode
 		}
+		if invalidRetentionDays(w, request.RetentionDays) {
+			return
+		}
 
 		group := Group{
 			GroupID:      GenerateID(),
 			Name:         censor(request.Name),
 			Members:      []UserID{user.UserID},
+			Owner:        user.UserID,
 			CalendarMode: calendarMode,
 		}
+		if request.RetentionDays != nil {
+			group.RetentionDays = *request.RetentionDays
+		}
 		if err := database.CreateGroup(group); err != nil {
 			http.Error(w, "could not create group", http.StatusInternalServerError)
 			return
@@ -132,7 +178,7 @@ type GroupKeyType struct{}
 var GroupKey = GroupKeyType(struct{}{})
 
 // API's related to a specific group.
-func RestSpecificGroupAPI(router *mux.Router, database Database, notification Notification) {
+func RestSpecificGroupAPI(router *mux.Router, database Database, notification Notification, scheduler Scheduler) {
 	router.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			groupID, ok := ParseUint64PathParameter(w, r, "groupID")
@@ -144,63 +190,77 @@ func RestSpecificGroupAPI(router *mux.Router, database Database, notification No
 				http.Error(w, "could not read group", http.StatusInternalServerError)
 				return
 			}
-			if group == nil {
+			if group == nil || group.Archived != 0 {
 				http.Error(w, "no such group", http.StatusNotFound)
 				return
 			}
+			// Groups created before roles existed get an owner, which is saved
+			// with their next update (see `updateAndNotifyGroup`).
+			group.ensureOwner()
 			rWithContext := r.WithContext(context.WithValue(r.Context(), GroupKey, group))
 			next.ServeHTTP(w, rWithContext)
 		})
 	})
-	RestGroupActivityAPI(AddHandler(router, "/activity"), database, notification)
+	RestGroupActivityAPI(AddHandler(router, "/activity"), database, notification, scheduler)
+	RestGroupAnnouncementAPI(AddHandler(router, "/announcement"), database, notification, scheduler)
 	RestGroupAvailabilityAPI(AddHandler(router, "/availability"), database, notification)
-	RestGroupChatAPI(AddHandler(router, "/chat"), database, notification)
+	RestGroupChatAPI(AddHandler(router, "/chat"), database, notification, scheduler)
+	RestGroupInviteAPI(AddHandler(router, "/invite"), database, notification)
+	RestGroupJoinAPI(AddHandler(router, "/join"), database, notification)
+	RestGroupMemberAPI(AddHandler(router, "/member"), database, notification)
+	RestGroupBanAPI(AddHandler(router, "/ban"), database, notification)
 	RestGroupPollAPI(AddHandler(router, "/poll"), database, notification)
-	RestGroupTaskAPI(AddHandler(router, "/task"), database, notification)
+	RestGroupTaskAPI(AddHandler(router, "/task"), database, notification, scheduler)
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		user := r.Context().Value(UserKey).(*User)
 		group := r.Context().Value(GroupKey).(*Group)
 		switch r.Method {
 		case http.MethodGet:
 			if !group.IsMember(user.UserID) {
-				if invalidAppend(w, user.Groups, maxGroupsPerUser) {
-					return
-				}
-				if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
-					group.Members = append(group.Members, user.UserID)
-					return nil
-				}, database, notification); err != nil {
-					http.Error(w, "could not join group", http.StatusInternalServerError)
-					return
-				}
-				group.Members = append(group.Members, user.UserID)
+				http.Error(w, "not a member of group (redeem an invite to join)", http.StatusForbidden)
+				return
 			}
 
 			response := GetGroupResponse{
 				Name:           censor(group.Name),
 				CalendarMode:   group.CalendarMode,
+				RetentionDays:  group.RetentionDays,
 				Members:        group.Members,
+				Owner:          group.Owner,
+				Admins:         append([]UserID{}, group.Admins...),
 				Availabilities: []GetGroupResponseAvailability{},
 				Activities:     []GetGroupResponseActivity{},
 				Tasks:          []GetGroupResponseTask{},
+				Polls:          []GetGroupResponsePoll{},
+				Pins:           []GetGroupResponsePin{},
+				Announcements:  []GetGroupResponseAnnouncement{},
 			}
 
-			if group.Poll != nil {
-				response.Poll = &GetGroupResponsePoll{
-					Title:   censor(group.Poll.Title),
-					Options: []GetGroupResponsePollOption{},
+			// Show the legacy poll, if any, as it will be once migrated.
+			group.migratePoll()
+			for _, poll := range group.Polls {
+				responsePoll := GetGroupResponsePoll{
+					PollID:    poll.PollID,
+					Creator:   poll.Creator,
+					Title:     censor(poll.Title),
+					Timestamp: poll.Timestamp,
+					Closed:    poll.Closed,
+					Archived:  poll.Archived,
+					Options:   []GetGroupResponsePollOption{},
 				}
-				for _, option := range group.Poll.Options {
-					response.Poll.Options = append(response.Poll.Options, GetGroupResponsePollOption{
+				for _, option := range poll.Options {
+					responsePoll.Options = append(responsePoll.Options, GetGroupResponsePollOption{
 						Name:  censor(option.Name),
 						Votes: append([]UserID{}, option.Votes...),
 					})
 				}
+				response.Polls = append(response.Polls, responsePoll)
 			}
 
 			for _, activity := range group.Activities {
 				response.Activities = append(response.Activities, GetGroupResponseActivity{
 					ActivityID: activity.ActivityID,
+					Creator:    activity.Creator,
 					Title:      censor(activity.Title),
 					Date:       activity.Date,
 					Start:      activity.Start,
@@ -225,13 +285,40 @@ func RestSpecificGroupAPI(router *mux.Router, database Database, notification No
 					Title:     censor(task.Title),
 					Assignee:  task.Assignee,
 					Completed: task.Completed,
+					Due:       task.Due,
+				})
+			}
+
+			for _, pin := range group.Pins {
+				message, err := database.ReadMessage(group.GroupID, pin.MessageID)
+				if err != nil || message == nil || message.Deleted {
+					// Skip messages that can't be shown.
+					continue
+				}
+				response.Pins = append(response.Pins, GetGroupResponsePin{
+					MessageID: pin.MessageID,
+					Pinner:    pin.Pinner,
+					Timestamp: pin.Timestamp,
+					Sender:    message.Sender,
+					Content:   message.Content,
+				})
+			}
+
+			// Expired announcements may not have been removed yet.
+			for _, announcement := range group.LiveAnnouncements(time.Now()) {
+				response.Announcements = append(response.Announcements, GetGroupResponseAnnouncement{
+					AnnouncementID: announcement.AnnouncementID,
+					Creator:        announcement.Creator,
+					Content:        censor(announcement.Content),
+					Timestamp:      announcement.Timestamp,
+					Expiry:         announcement.Expiry,
 				})
 			}
 
 			WriteJSON(w, response)
 		case http.MethodPatch:
-			if !group.IsMember(user.UserID) {
-				http.Error(w, "not a member of group", http.StatusUnauthorized)
+			if !group.IsAdmin(user.UserID) {
+				http.Error(w, "must be group admin", http.StatusUnauthorized)
 				return
 			}
 
@@ -247,7 +334,11 @@ func RestSpecificGroupAPI(router *mux.Router, database Database, notification No
 			if request.CalendarMode != "" && invalidCalendarMode(w, request.CalendarMode) {
 				return
 			}
+			if invalidRetentionDays(w, request.RetentionDays) {
+				return
+			}
 
+			var updated Group
 			if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
 				if request.Name != "" {
 					group.Name = request.Name
@@ -255,41 +346,81 @@ func RestSpecificGroupAPI(router *mux.Router, database Database, notification No
 				if request.CalendarMode != "" {
 					group.CalendarMode = request.CalendarMode
 				}
+				if request.RetentionDays != nil {
+					group.RetentionDays = *request.RetentionDays
+				}
+				updated = *group
 				return nil
 			}, database, notification); err != nil {
 				http.Error(w, "could not update group", http.StatusInternalServerError)
 				return
 			}
 
+			if request.RetentionDays != nil && *request.RetentionDays != group.RetentionDays {
+				// Apply the new setting to messages sent before the change,
+				// deleting those that are already too old.
+				if err := database.UpdateMessageExpiry(group.GroupID, updated.MessageExpiry); err != nil {
+					log.Printf("could not update message expiry of group %d: %v\n", group.GroupID, err)
+				}
+				if err := enforceRetention(&updated, time.Now(), database, notification); err != nil {
+					log.Printf("could not enforce retention of group %d: %v\n", group.GroupID, err)
+				}
+			}
+
 			WriteJSON(w, nil)
 		case http.MethodDelete:
 			if !group.IsMember(user.UserID) {
 				http.Error(w, "not a member of group", http.StatusUnauthorized)
 				return
 			}
-			if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
-				group.Members = slices.DeleteFunc(group.Members, func(member UserID) bool {
-					return member == user.UserID
-				})
-				group.Availabilities = slices.DeleteFunc(group.Availabilities, func(availability Availability) bool {
-					return availability.UserID == user.UserID
-				})
-				for _, activity := range group.Activities {
-					activity.Confirmed = slices.DeleteFunc(activity.Confirmed, func(confirmed UserID) bool {
-						return confirmed == user.UserID
-					})
+			if r.URL.Query().Get("delete") == "true" {
+				if group.RoleOf(user.UserID) != RoleOwner {
+					http.Error(w, "must be group owner", http.StatusUnauthorized)
+					return
 				}
-				// TODO: suboptimal. ideally tasks could be reverted to
-				// no assignee, but that complicates the protocol.
-				group.Tasks = slices.DeleteFunc(group.Tasks, func(task Task) bool {
-					return task.Assignee == user.UserID
-				})
-				if group.Poll != nil {
-					for _, option := range group.Poll.Options {
-						option.Votes = slices.DeleteFunc(option.Votes, func(vote UserID) bool {
-							return vote == user.UserID
-						})
+				// Archive first, so the group is inaccessible while members are removed.
+				var members []UserID
+				if err := database.UpdateGroup(group.GroupID, func(group *Group) error {
+					group.ensureOwner()
+					if group.RoleOf(user.UserID) != RoleOwner {
+						return fmt.Errorf("no longer group owner")
 					}
+					members = group.Members
+					group.Members = []UserID{}
+					group.Archived = unixMillis()
+					return nil
+				}); err != nil {
+					http.Error(w, "could not delete group (part 1)", http.StatusInternalServerError)
+					return
+				}
+				for _, member := range members {
+					// Ignore errors, as the group will be skipped once it no longer exists.
+					_ = database.UpdateUser(member, func(user *User) error {
+						user.Groups = slices.DeleteFunc(user.Groups, func(groupID GroupID) bool { return groupID == group.GroupID })
+						return nil
+					})
+					notifyUser(member, GroupDeleted{
+						Deleted: GroupDeletedGroup{
+							GroupID: group.GroupID,
+						},
+					}, database, notification)
+				}
+				if err := deleteGroup(group.GroupID, database); err != nil {
+					// Fall back to deleting it like an abandoned group.
+					scheduleGroupDeletion(group.GroupID, scheduler)
+					http.Error(w, "could not delete group (part 2)", http.StatusInternalServerError)
+					return
+				}
+				WriteJSON(w, nil)
+				return
+			}
+
+			archived := false
+			if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+				group.removeMember(user.UserID)
+				archived = len(group.Members) == 0
+				if archived {
+					group.Archived = unixMillis()
 				}
 				return nil
 			}, database, notification); err != nil {
@@ -304,7 +435,9 @@ func RestSpecificGroupAPI(router *mux.Router, database Database, notification No
 				return
 			}
 
-			// TODO: delete group if no members left
+			if archived {
+				scheduleGroupDeletion(group.GroupID, scheduler)
+			}
 
 			WriteJSON(w, nil)
 		default:
@@ -322,3 +455,48 @@ func (group *Group) IsMember(userID UserID) bool {
 	}
 	return false
 }
+
+// Helper to check if a chat message is pinned in a group.
+func (group *Group) IsPinned(messageID MessageID) bool {
+	return slices.ContainsFunc(group.Pins, func(pin Pin) bool { return pin.MessageID == messageID })
+}
+
+// Helper to read the members of a group, skipping any that cannot be read.
+func readMembers(group *Group, database Database) []User {
+	members := make([]User, 0, len(group.Members))
+	for _, userID := range group.Members {
+		if user, err := database.ReadUser(userID); err == nil && user != nil {
+			members = append(members, *user)
+		}
+	}
+	return members
+}
+
+// Helper to check if a group has been abandoned for long enough to delete.
+func (group *Group) IsDeletable(now time.Time) bool {
+	if group.Archived == 0 || len(group.Members) > 0 {
+		return false
+	}
+	archived := time.UnixMilli(int64(group.Archived))
+	return !now.Before(archived.Add(groupDeletionDelay))
+}
+
+// Schedules an archived group to be deleted once it is deletable.
+//
+// Errors are logged, since there is no one left to report them to.
+func scheduleGroupDeletion(groupID GroupID, scheduler Scheduler) {
+	// Leeway in case the scheduler is early.
+	date := time.Now().Add(groupDeletionDelay + time.Minute)
+	if _, err := scheduler.Schedule(date, Activation{GroupID: &groupID}); err != nil {
+		log.Printf("could not schedule deletion of group %d: %v\n", groupID, err)
+	}
+}
+
+// Permanently deletes a group and all of its chat messages.
+func deleteGroup(groupID GroupID, database Database) error {
+	// Delete messages first, so that a failure can be retried.
+	if _, err := database.DeleteMessages(groupID, math.MaxUint64); err != nil {
+		return err
+	}
+	return database.DeleteGroup(groupID)
+}
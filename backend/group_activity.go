@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 	"slices"
 
@@ -22,7 +23,7 @@ type PatchActivityRequest struct {
 }
 
 // API's related to activities within a group.
-func RestGroupActivityAPI(router *mux.Router, database Database, notification Notification) {
+func RestGroupActivityAPI(router *mux.Router, database Database, notification Notification, scheduler Scheduler) {
 	router.HandleFunc("/{activityID}/", func(w http.ResponseWriter, r *http.Request) {
 		activityID, ok := ParseUint64PathParameter(w, r, "activityID")
 		if !ok {
@@ -54,7 +55,9 @@ func RestGroupActivityAPI(router *mux.Router, database Database, notification No
 				return
 			}
 
+			var rescheduled *Activity
 			if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+				rescheduled = nil
 				for i := range group.Activities {
 					activity := &group.Activities[i]
 					if activity.ActivityID != activityID {
@@ -67,11 +70,17 @@ func RestGroupActivityAPI(router *mux.Router, database Database, notification No
 						group.Activities[i].Date = request.Date
 						// If the date changes, those that confirmed may no longer be able to attend.
 						group.Activities[i].Confirmed = []UserID{}
+						// Members should be reminded of the new date.
+						group.Activities[i].Reminded = nil
+						rescheduled = activity
 					}
 					if request.Start != "" && request.Start != activity.Start {
 						group.Activities[i].Start = request.Start
 						// If the start changes, those that confirmed may no longer be able to attend.
 						group.Activities[i].Confirmed = []UserID{}
+						// Members should be reminded of the new start.
+						group.Activities[i].Reminded = nil
+						rescheduled = activity
 					}
 					if request.End != "" && request.End != activity.End {
 						group.Activities[i].End = request.End
@@ -95,8 +104,29 @@ func RestGroupActivityAPI(router *mux.Router, database Database, notification No
 				return
 			}
 
+			if rescheduled != nil {
+				if jobID := scheduleActivityReminder(group.GroupID, *rescheduled, scheduler); jobID != rescheduled.ReminderJob {
+					if err := database.UpdateGroup(group.GroupID, func(group *Group) error {
+						for i := range group.Activities {
+							if group.Activities[i].ActivityID == activityID {
+								group.Activities[i].ReminderJob = jobID
+							}
+						}
+						return nil
+					}); err != nil {
+						log.Printf("could not save reminder job of activity %d: %v\n", activityID, err)
+					}
+				}
+			}
+
 			WriteJSON(w, nil)
 		case http.MethodDelete:
+			if !group.IsAdmin(user.UserID) && !slices.ContainsFunc(group.Activities, func(a Activity) bool {
+				return a.ActivityID == activityID && a.Creator == user.UserID
+			}) {
+				http.Error(w, "must be activity creator or group admin", http.StatusUnauthorized)
+				return
+			}
 			if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
 				group.Activities = slices.DeleteFunc(group.Activities, func(activity Activity) bool { return activity.ActivityID == activityID })
 				return nil
@@ -104,6 +134,11 @@ func RestGroupActivityAPI(router *mux.Router, database Database, notification No
 				http.Error(w, "could not delete activity", http.StatusInternalServerError)
 				return
 			}
+			for _, activity := range group.Activities {
+				if activity.ActivityID == activityID {
+					cancelReminder(activity.ReminderJob, scheduler)
+				}
+			}
 			WriteJSON(w, nil)
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -121,10 +156,6 @@ func RestGroupActivityAPI(router *mux.Router, database Database, notification No
 			return
 		}
 
-		if invalidDate(w, request.Date) || invalidTime(w, request.Start) || invalidTime(w, request.End) {
-			return
-		}
-
 		user := r.Context().Value(UserKey).(*User)
 		group := r.Context().Value(GroupKey).(*Group)
 
@@ -133,29 +164,50 @@ func RestGroupActivityAPI(router *mux.Router, database Database, notification No
 			return
 		}
 
-		if invalidAppend(w, group.Activities, groupMaxActivities) {
-			return
-		}
-
-		if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
-			confirmed := []UserID{}
-			if request.Confirm != nil && *request.Confirm {
-				confirmed = append(confirmed, user.UserID)
-			}
-			group.Activities = append(group.Activities, Activity{
-				ActivityID: GenerateID(),
-				Title:      request.Title,
-				Date:       request.Date,
-				Start:      request.Start,
-				End:        request.End,
-				Confirmed:  []UserID{},
-			})
-			return nil
-		}, database, notification); err != nil {
-			http.Error(w, "could not create activity", http.StatusInternalServerError)
+		activity, ok := createActivity(w, group, user, request, database, notification, scheduler)
+		if !ok {
 			return
 		}
+		postSystemMessage(group, MessageKindActivityCreated, user.UserID, activity.Title, database, notification)
 
 		WriteJSON(w, nil)
 	})
 }
+
+// Helper to create an activity, shared by the activity API and chat commands.
+// The user must be a member.
+//
+// If returns false, error has been sent and should return.
+func createActivity(w http.ResponseWriter, group *Group, user *User, request PatchActivityRequest, database Database, notification Notification, scheduler Scheduler) (Activity, bool) {
+	if invalidDate(w, request.Date) || invalidTime(w, request.Start) || invalidTime(w, request.End) {
+		return Activity{}, false
+	}
+
+	if invalidAppend(w, group.Activities, groupMaxActivities) {
+		return Activity{}, false
+	}
+
+	activity := Activity{
+		ActivityID: GenerateID(),
+		Creator:    user.UserID,
+		Title:      request.Title,
+		Date:       request.Date,
+		Start:      request.Start,
+		End:        request.End,
+		Confirmed:  []UserID{},
+	}
+	activity.ReminderJob = scheduleActivityReminder(group.GroupID, activity, scheduler)
+	if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+		confirmed := []UserID{}
+		if request.Confirm != nil && *request.Confirm {
+			confirmed = append(confirmed, user.UserID)
+		}
+		group.Activities = append(group.Activities, activity)
+		return nil
+	}, database, notification); err != nil {
+		cancelReminder(activity.ReminderJob, scheduler)
+		http.Error(w, "could not create activity", http.StatusInternalServerError)
+		return Activity{}, false
+	}
+	return activity, true
+}
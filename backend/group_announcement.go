@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	announcementContentMinLen = 1
+	announcementContentMaxLen = 500
+	groupMaxAnnouncements     = 8
+)
+
+// New announcement sent over JSON.
+type PatchAnnouncementRequest struct {
+	Content string `json:"content"`
+	// Unix millisecond time when the announcement expires, if ever.
+	Expiry UnixMillis `json:"expiry,omitempty"`
+}
+
+// New announcement ID sent over JSON.
+type PatchAnnouncementResponse struct {
+	AnnouncementID AnnouncementID `json:"announcementId"`
+}
+
+// API's related to announcements within a group.
+func RestGroupAnnouncementAPI(router *mux.Router, database Database, notification Notification, scheduler Scheduler) {
+	router.HandleFunc("/{announcementID}/", func(w http.ResponseWriter, r *http.Request) {
+		announcementID, ok := ParseUint64PathParameter(w, r, "announcementID")
+		if !ok {
+			return
+		}
+
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user := r.Context().Value(UserKey).(*User)
+		group := r.Context().Value(GroupKey).(*Group)
+
+		if !group.IsAdmin(user.UserID) {
+			http.Error(w, "must be group admin", http.StatusUnauthorized)
+			return
+		}
+
+		index := slices.IndexFunc(group.Announcements, func(announcement Announcement) bool {
+			return announcement.AnnouncementID == announcementID
+		})
+		if index < 0 {
+			http.Error(w, "announcement not found", http.StatusNotFound)
+			return
+		}
+
+		if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+			group.Announcements = slices.DeleteFunc(slices.Clone(group.Announcements), func(announcement Announcement) bool {
+				return announcement.AnnouncementID == announcementID
+			})
+			return nil
+		}, database, notification); err != nil {
+			http.Error(w, "could not delete announcement", http.StatusInternalServerError)
+			return
+		}
+		cancelAnnouncementExpiry(group.Announcements[index], scheduler)
+
+		WriteJSON(w, nil)
+	})
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var request PatchAnnouncementRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "could not decode body", http.StatusBadRequest)
+			return
+		}
+
+		if invalidString(w, request.Content, announcementContentMinLen, announcementContentMaxLen) {
+			return
+		}
+		now := time.Now()
+		if request.Expiry != 0 && request.Expiry <= UnixMillis(now.UnixMilli()) {
+			http.Error(w, "expiry must be in the future", http.StatusBadRequest)
+			return
+		}
+
+		user := r.Context().Value(UserKey).(*User)
+		group := r.Context().Value(GroupKey).(*Group)
+
+		if !group.IsAdmin(user.UserID) {
+			http.Error(w, "must be group admin", http.StatusUnauthorized)
+			return
+		}
+
+		if invalidAppend(w, group.LiveAnnouncements(now), groupMaxAnnouncements) {
+			return
+		}
+
+		announcement := Announcement{
+			AnnouncementID: GenerateID(),
+			Creator:        user.UserID,
+			Content:        request.Content,
+			Timestamp:      UnixMillis(now.UnixMilli()),
+			Expiry:         request.Expiry,
+		}
+		if announcement.Expiry != 0 {
+			announcement.ExpiryJob = scheduleAnnouncementExpiry(group.GroupID, announcement, scheduler)
+		}
+
+		if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+			// Make room by forgetting expired announcements.
+			group.Announcements = append(group.LiveAnnouncements(now), announcement)
+			return nil
+		}, database, notification); err != nil {
+			cancelAnnouncementExpiry(announcement, scheduler)
+			http.Error(w, "could not create announcement", http.StatusInternalServerError)
+			return
+		}
+
+		pushGroup(group, AnnouncementPushed{
+			Announcement: AnnouncementPushedAnnouncement{
+				Group:     censor(group.Name),
+				Timestamp: announcement.Timestamp,
+				Sender:    censor(user.Name),
+				Content:   censor(announcement.Content),
+			},
+		}, database)
+
+		WriteJSON(w, PatchAnnouncementResponse{
+			AnnouncementID: announcement.AnnouncementID,
+		})
+	})
+}
+
+// Helper to list a group's announcements that have not expired by `now`.
+func (group *Group) LiveAnnouncements(now time.Time) []Announcement {
+	return slices.DeleteFunc(slices.Clone(group.Announcements), func(announcement Announcement) bool {
+		return announcement.IsExpired(now)
+	})
+}
+
+// Helper to check if an announcement has expired by `now`.
+func (announcement *Announcement) IsExpired(now time.Time) bool {
+	return announcement.Expiry != 0 && announcement.Expiry <= UnixMillis(now.UnixMilli())
+}
+
+// Schedules an activation of the group once an announcement expires.
+//
+// Returns the ID of the pending job, or "" if none could be scheduled. Errors
+// are logged, as expired announcements are hidden regardless.
+func scheduleAnnouncementExpiry(groupID GroupID, announcement Announcement, scheduler Scheduler) JobID {
+	// Leeway in case the scheduler is early.
+	date := time.UnixMilli(int64(announcement.Expiry)).Add(time.Second)
+	jobID, err := scheduler.Schedule(date, Activation{GroupID: &groupID})
+	if err != nil {
+		log.Printf("could not schedule expiry of announcement %d: %v\n", announcement.AnnouncementID, err)
+		return ""
+	}
+	return jobID
+}
+
+// Cancels the pending expiry job of an announcement, if there is one.
+func cancelAnnouncementExpiry(announcement Announcement, scheduler Scheduler) {
+	if announcement.ExpiryJob == "" {
+		return
+	}
+	if err := scheduler.Cancel(announcement.ExpiryJob); err != nil {
+		log.Printf("could not cancel expiry job %s: %v\n", announcement.ExpiryJob, err)
+	}
+}
+
+// Removes a group's expired announcements, notifying members if there were
+// any. The group is only updated if `group` (as already read) has any.
+func expireAnnouncements(group *Group, now time.Time, database Database, notification Notification) error {
+	if len(group.LiveAnnouncements(now)) == len(group.Announcements) {
+		return nil
+	}
+	var g *Group
+	expired := false
+	err := database.UpdateGroup(group.GroupID, func(group *Group) error {
+		g = group
+		live := group.LiveAnnouncements(now)
+		expired = len(live) < len(group.Announcements)
+		group.Announcements = live
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not expire announcements: %w", err)
+	}
+	if expired {
+		notifyGroup(g, nil, database, notification)
+	}
+	return nil
+}
@@ -72,10 +72,6 @@ func RestGroupAvailabilityAPI(router *mux.Router, database Database, notificatio
 			return
 		}
 
-		if invalidDate(w, request.Date) || invalidTime(w, request.Start) || invalidTime(w, request.End) {
-			return
-		}
-
 		user := r.Context().Value(UserKey).(*User)
 		group := r.Context().Value(GroupKey).(*Group)
 
@@ -84,24 +80,39 @@ func RestGroupAvailabilityAPI(router *mux.Router, database Database, notificatio
 			return
 		}
 
-		if invalidAppend(w, group.Availabilities, groupMaxAvailabilities) {
-			return
-		}
-
-		if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
-			group.Availabilities = append(group.Availabilities, Availability{
-				AvailabilityID: GenerateID(),
-				UserID:         user.UserID,
-				Date:           request.Date,
-				Start:          request.Start,
-				End:            request.End,
-			})
-			return nil
-		}, database, notification); err != nil {
-			http.Error(w, "could not create availability", http.StatusInternalServerError)
+		if !createAvailability(w, group, user, request, database, notification) {
 			return
 		}
 
 		WriteJSON(w, nil)
 	})
 }
+
+// Helper to add a member's availability, shared by the availability API and
+// chat commands. The user must be a member.
+//
+// If returns false, error has been sent and should return.
+func createAvailability(w http.ResponseWriter, group *Group, user *User, request PatchAvailabilityRequest, database Database, notification Notification) bool {
+	if invalidDate(w, request.Date) || invalidTime(w, request.Start) || invalidTime(w, request.End) {
+		return false
+	}
+
+	if invalidAppend(w, group.Availabilities, groupMaxAvailabilities) {
+		return false
+	}
+
+	if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+		group.Availabilities = append(group.Availabilities, Availability{
+			AvailabilityID: GenerateID(),
+			UserID:         user.UserID,
+			Date:           request.Date,
+			Start:          request.Start,
+			End:            request.End,
+		})
+		return nil
+	}, database, notification); err != nil {
+		http.Error(w, "could not create availability", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
@@ -1,11 +1,20 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log"
+	"maps"
 	"math"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
 )
@@ -13,29 +22,135 @@ import (
 const (
 	chatMessageMinLen = 1
 	chatMessageMaxLen = 500
+	// Messages per page, if the client doesn't say.
+	chatDefaultLimit = 20
+	// Most messages per page.
+	chatMaxLimit = 100
+	// Attempts to find an unused message ID.
+	chatCreateAttempts = 4
+	// Most unread messages counted per group.
+	chatMaxUnread = 99
+	groupMaxPins  = 16
+	// Most runes of a message shown around a search match, and how many of
+	// them may precede the first match.
+	chatSnippetLen  = 120
+	chatSnippetLead = 30
+	// Message IDs are the millisecond timestamp scaled by this, plus a sequence
+	// number. Must keep IDs within JavaScript's safe integers.
+	messageIDsPerMillisecond = 1000
 )
 
+// Kinds of system messages, which record group events in the chat.
+const (
+	MessageKindPollCreated     = "pollCreated"
+	MessageKindActivityCreated = "activityCreated"
+	MessageKindTaskCompleted   = "taskCompleted"
+	MessageKindMemberJoined    = "memberJoined"
+	// Only posted in reply to chat commands.
+	MessageKindTaskCreated       = "taskCreated"
+	MessageKindAvailabilityAdded = "availabilityAdded"
+)
+
+// Emoji that members may react to chat messages with.
+var chatReactions = []string{"👍", "👎", "❤️", "😂", "😮", "😢", "🎉"}
+
 // Chat sent over JSON.
 type GetChatResponse struct {
 	Messages []GetChatResponseMessage `json:"messages"`
-	Continue bool                     `json:"continue"`
+	// Cursor for the next page, or empty if there are no more messages.
+	Continue string `json:"continue,omitempty"`
+}
+
+// New chat message ID sent over JSON.
+type PatchChatResponse struct {
+	MessageID MessageID `json:"messageId"`
+}
+
+// Chat search results sent over JSON.
+type GetChatSearchResponse struct {
+	Results []GetChatSearchResponseResult `json:"results"`
+	// Cursor for the next page, or empty if there are no more results.
+	Continue string `json:"continue,omitempty"`
+}
+
+// Message matching a search sent over JSON.
+type GetChatSearchResponseResult struct {
+	MessageID MessageID `json:"messageId"`
+	Sender    UserID    `json:"sender"`
+	Timestamp uint64    `json:"timestamp"`
+	// The message this replies to, if it is in a thread.
+	ParentID MessageID `json:"parentId,omitempty"`
+	// Excerpt of the message around the matching words.
+	Snippet []GetChatSearchResponseSnippet `json:"snippet"`
+}
+
+// Part of a search result's excerpt sent over JSON.
+type GetChatSearchResponseSnippet struct {
+	Text string `json:"text"`
+	// Whether the text is a word that was searched for.
+	Match bool `json:"match,omitempty"`
+}
+
+// The position of a page of chat, encoded into an opaque cursor.
+type chatCursor struct {
+	Start   MessageID `json:"s"`
+	End     MessageID `json:"e"`
+	Forward bool      `json:"f,omitempty"`
 }
 
 // Message sent over JSON.
 type GetChatResponseMessage struct {
-	Sender    UserID `json:"sender"`
-	Timestamp uint64 `json:"timestamp"`
-	Content   string `json:"content"`
+	MessageID MessageID `json:"messageId"`
+	Sender    UserID    `json:"sender"`
+	Timestamp uint64    `json:"timestamp"`
+	Content   string    `json:"content"`
+	// The kind of system message, if it is one.
+	Kind     string `json:"kind,omitempty"`
+	EditedAt uint64 `json:"editedAt,omitempty"`
+	Deleted  bool   `json:"deleted,omitempty"`
+	// Members that reacted, by emoji.
+	Reactions map[string][]UserID `json:"reactions,omitempty"`
+	// The message this replies to, if it is in a thread.
+	ParentID   MessageID `json:"parentId,omitempty"`
+	ReplyCount uint64    `json:"replyCount,omitempty"`
+	LastReply  uint64    `json:"lastReply,omitempty"`
+	// Members mentioned by name, like "@Alex".
+	Mentions []UserID `json:"mentions,omitempty"`
 }
 
 // New chat sent over JSON.
 type PatchChatRequest struct {
 	Content string `json:"content"`
+	// The message to reply to, if any.
+	ParentID MessageID `json:"parentId,omitempty"`
+}
+
+// Read position sent over JSON.
+type PatchChatReadRequest struct {
+	// The latest message read, or 0 for the latest message sent.
+	MessageID MessageID `json:"messageId"`
+}
+
+// Edited chat message sent over JSON.
+type PatchChatMessageRequest struct {
+	Content string `json:"content"`
 }
 
 // API's related to chat within a group.
-func RestGroupChatAPI(router *mux.Router, database Database, notification Notification) {
-	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+func RestGroupChatAPI(router *mux.Router, database Database, notification Notification, scheduler Scheduler) {
+	// Must precede "/{messageID}/", as mux uses the first matching route.
+	router.HandleFunc("/read/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var request PatchChatReadRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "could not decode body", http.StatusBadRequest)
+			return
+		}
+
 		user := r.Context().Value(UserKey).(*User)
 		group := r.Context().Value(GroupKey).(*Group)
 
@@ -44,38 +159,397 @@ func RestGroupChatAPI(router *mux.Router, database Database, notification Notifi
 			return
 		}
 
-		switch r.Method {
-		case http.MethodGet:
-			startTimeString := r.URL.Query().Get("start")
-			startTime, err := strconv.ParseUint(startTimeString, 10, 64)
-			if err != nil {
-				startTime = 0
+		latest, _, err := database.ReadMessages(group.GroupID, 0, 0, math.MaxUint64, 1, false, true)
+		if err != nil {
+			http.Error(w, "could not read chat", http.StatusInternalServerError)
+			return
+		}
+		if len(latest) == 0 {
+			WriteJSON(w, nil)
+			return
+		}
+		// Read positions only move forward, so never past the latest message.
+		messageID := latest[0].MessageID
+		if request.MessageID != 0 {
+			messageID = min(request.MessageID, messageID)
+		}
+
+		var changed bool
+		if err := database.UpdateUser(user.UserID, func(user *User) error {
+			// Forget groups the user has left.
+			user.ReadPositions = slices.DeleteFunc(slices.Clone(user.ReadPositions), func(position ReadPosition) bool {
+				return !slices.Contains(user.Groups, position.GroupID)
+			})
+			// Another connection may have read further already.
+			changed = messageID > user.LastRead(group.GroupID)
+			if !changed {
+				return nil
 			}
-			endTimeString := r.URL.Query().Get("end")
-			endTime, err := strconv.ParseUint(endTimeString, 10, 64)
+			user.ReadPositions = slices.DeleteFunc(user.ReadPositions, func(position ReadPosition) bool {
+				return position.GroupID == group.GroupID
+			})
+			user.ReadPositions = append(user.ReadPositions, ReadPosition{GroupID: group.GroupID, MessageID: messageID})
+			return nil
+		}); err != nil {
+			http.Error(w, "could not update read position", http.StatusInternalServerError)
+			return
+		}
+
+		if changed {
+			// Keep the user's other connections in sync.
+			notifyUser(user.UserID, ReadChanged{Read: ReadChangedRead{
+				GroupID:   group.GroupID,
+				MessageID: messageID,
+			}}, database, notification)
+		}
+
+		WriteJSON(w, nil)
+	})
+	router.HandleFunc("/search/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user := r.Context().Value(UserKey).(*User)
+		group := r.Context().Value(GroupKey).(*Group)
+
+		if !group.IsMember(user.UserID) {
+			http.Error(w, "not a member of group", http.StatusUnauthorized)
+			return
+		}
+
+		query := r.URL.Query()
+
+		search := MessageSearch{Terms: searchTerms(query.Get("q"))}
+		if len(search.Terms) == 0 {
+			http.Error(w, "invalid query", http.StatusBadRequest)
+			return
+		}
+		// Dropping a word would match more messages than asked for.
+		if slices.ContainsFunc(searchWords(query.Get("q")), func(word string) bool { return len(word) > searchTermMaxLen }) {
+			http.Error(w, "query word too long", http.StatusBadRequest)
+			return
+		}
+		if senderString := query.Get("sender"); senderString != "" {
+			sender, err := strconv.ParseUint(senderString, 10, 64)
 			if err != nil {
-				endTime = math.MaxUint64
+				http.Error(w, "invalid sender", http.StatusBadRequest)
+				return
 			}
+			search.Sender = sender
+		}
 
-			messages, more, err := database.ReadMessages(group.GroupID, startTime, endTime)
-			if err != nil {
-				http.Error(w, "could not read chat", http.StatusInternalServerError)
+		limit, cursor, ok := parseChatPage(w, r)
+		if !ok {
+			return
+		}
+		search.Start = cursor.Start
+		search.End = cursor.End
+
+		messages, next, err := database.SearchMessages(group.GroupID, search, limit)
+		if err != nil {
+			http.Error(w, "could not search chat", http.StatusInternalServerError)
+			return
+		}
+
+		var response GetChatSearchResponse
+
+		response.Results = []GetChatSearchResponseResult{}
+		for _, message := range messages {
+			response.Results = append(response.Results, GetChatSearchResponseResult{
+				MessageID: message.MessageID,
+				Sender:    message.Sender,
+				Timestamp: message.Timestamp,
+				ParentID:  message.ParentID,
+				Snippet:   chatSnippet(message.Content, search.Terms),
+			})
+		}
+		if next != 0 {
+			cursor.End = next
+			response.Continue = encodeChatCursor(cursor)
+		}
+
+		WriteJSON(w, response)
+	})
+	router.HandleFunc("/{messageID}/", func(w http.ResponseWriter, r *http.Request) {
+		messageID, ok := ParseUint64PathParameter(w, r, "messageID")
+		if !ok {
+			return
+		}
+
+		user := r.Context().Value(UserKey).(*User)
+		group := r.Context().Value(GroupKey).(*Group)
+
+		if !group.IsMember(user.UserID) {
+			http.Error(w, "not a member of group", http.StatusUnauthorized)
+			return
+		}
+
+		message, ok := readChatMessage(w, group.GroupID, messageID, database)
+		if !ok {
+			return
+		}
+
+		if message.Sender != user.UserID && !group.IsAdmin(user.UserID) {
+			http.Error(w, "must be message sender or group admin", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPatch:
+			var request PatchChatMessageRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "could not decode body", http.StatusBadRequest)
 				return
 			}
 
-			var chat GetChatResponse
+			if invalidString(w, request.Content, chatMessageMinLen, chatMessageMaxLen) {
+				return
+			}
+			if message.Kind != "" {
+				http.Error(w, "cannot edit system message", http.StatusBadRequest)
+				return
+			}
+
+			var edited Message
+			content := censor(request.Content)
+			mentions := parseMentions(content, readMembers(group, database))
+			if err := database.UpdateMessage(group.GroupID, messageID, func(message *Message) error {
+				if message.Deleted {
+					return fmt.Errorf("message deleted")
+				}
+				message.Content = content
+				message.Mentions = mentions
+				message.EditedAt = unixMillis()
+				edited = *message
+				return nil
+			}); err != nil {
+				http.Error(w, "could not edit message", http.StatusInternalServerError)
+				return
+			}
+
+			notifyGroup(group, MessageEdited{Message: MessageEditedMessage{
+				GroupID:   edited.GroupID,
+				MessageID: edited.MessageID,
+				EditedAt:  edited.EditedAt,
+				Content:   edited.Content,
+				Mentions:  edited.Mentions,
+			}}, database, notification)
+
+			WriteJSON(w, nil)
+		case http.MethodDelete:
+			if err := database.UpdateMessage(group.GroupID, messageID, func(message *Message) error {
+				// Leave a tombstone, so the message's place in the chat is kept.
+				message.Deleted = true
+				message.Content = ""
+				message.Mentions = nil
+				message.Reactions = nil
+				return nil
+			}); err != nil {
+				http.Error(w, "could not delete message", http.StatusInternalServerError)
+				return
+			}
+
+			notifyGroup(group, MessageDeleted{Message: MessageDeletedMessage{
+				GroupID:   group.GroupID,
+				MessageID: messageID,
+			}}, database, notification)
+
+			if group.IsPinned(messageID) {
+				if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+					group.Pins = slices.DeleteFunc(slices.Clone(group.Pins), func(pin Pin) bool { return pin.MessageID == messageID })
+					return nil
+				}, database, notification); err != nil {
+					log.Printf("could not unpin deleted message %d: %v\n", messageID, err)
+				}
+			}
+
+			WriteJSON(w, nil)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	router.HandleFunc("/{messageID}/thread/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		messageID, ok := ParseUint64PathParameter(w, r, "messageID")
+		if !ok {
+			return
+		}
+
+		user := r.Context().Value(UserKey).(*User)
+		group := r.Context().Value(GroupKey).(*Group)
+
+		if !group.IsMember(user.UserID) {
+			http.Error(w, "not a member of group", http.StatusUnauthorized)
+			return
+		}
+
+		// Replies outlive a deleted parent.
+		parent, err := database.ReadMessage(group.GroupID, messageID)
+		if err != nil {
+			http.Error(w, "could not read message", http.StatusInternalServerError)
+			return
+		}
+		if parent == nil {
+			http.Error(w, "message not found", http.StatusNotFound)
+			return
+		}
+
+		writeChatPage(w, r, group.GroupID, messageID, database)
+	})
+	router.HandleFunc("/{messageID}/reaction/{emoji}/", func(w http.ResponseWriter, r *http.Request) {
+		messageID, ok := ParseUint64PathParameter(w, r, "messageID")
+		if !ok {
+			return
+		}
+
+		emoji := mux.Vars(r)["emoji"]
+		if !slices.Contains(chatReactions, emoji) {
+			http.Error(w, "invalid emoji", http.StatusBadRequest)
+			return
+		}
+
+		user := r.Context().Value(UserKey).(*User)
+		group := r.Context().Value(GroupKey).(*Group)
+
+		if !group.IsMember(user.UserID) {
+			http.Error(w, "not a member of group", http.StatusUnauthorized)
+			return
+		}
+
+		if _, ok := readChatMessage(w, group.GroupID, messageID, database); !ok {
+			return
+		}
+
+		var react bool
+		switch r.Method {
+		case http.MethodPut:
+			react = true
+		case http.MethodDelete:
+			react = false
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var reacted []UserID
+		if err := database.UpdateMessage(group.GroupID, messageID, func(message *Message) error {
+			if message.Deleted {
+				return fmt.Errorf("message deleted")
+			}
+			// Copy rather than modify in place, as the transaction may be retried.
+			reacted = slices.DeleteFunc(slices.Clone(message.Reactions[emoji]), func(u UserID) bool { return u == user.UserID })
+			if react {
+				reacted = append(reacted, user.UserID)
+			}
+			reactions := maps.Clone(message.Reactions)
+			if reactions == nil {
+				reactions = make(map[string][]UserID)
+			}
+			if len(reacted) > 0 {
+				reactions[emoji] = reacted
+			} else {
+				delete(reactions, emoji)
+				reacted = []UserID{}
+			}
+			message.Reactions = reactions
+			return nil
+		}); err != nil {
+			http.Error(w, "could not update reaction", http.StatusInternalServerError)
+			return
+		}
+
+		notifyGroup(group, ReactionChanged{Reaction: ReactionChangedReaction{
+			GroupID:   group.GroupID,
+			MessageID: messageID,
+			Emoji:     emoji,
+			Users:     reacted,
+		}}, database, notification)
+
+		WriteJSON(w, nil)
+	})
+	router.HandleFunc("/{messageID}/pin/", func(w http.ResponseWriter, r *http.Request) {
+		messageID, ok := ParseUint64PathParameter(w, r, "messageID")
+		if !ok {
+			return
+		}
+
+		user := r.Context().Value(UserKey).(*User)
+		group := r.Context().Value(GroupKey).(*Group)
+
+		if !group.IsMember(user.UserID) {
+			http.Error(w, "not a member of group", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPut:
+			if _, ok := readChatMessage(w, group.GroupID, messageID, database); !ok {
+				return
+			}
+			if group.IsPinned(messageID) {
+				WriteJSON(w, nil)
+				return
+			}
+			if invalidAppend(w, group.Pins, groupMaxPins) {
+				return
+			}
 
-			chat.Messages = []GetChatResponseMessage{}
-			for _, message := range messages {
-				chat.Messages = append(chat.Messages, GetChatResponseMessage{
-					Sender:    message.Sender,
-					Timestamp: message.Timestamp,
-					Content:   message.Content,
+			if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+				if group.IsPinned(messageID) {
+					return nil
+				}
+				if len(group.Pins) >= groupMaxPins {
+					return fmt.Errorf("too many pins")
+				}
+				group.Pins = append(slices.Clip(group.Pins), Pin{
+					MessageID: messageID,
+					Pinner:    user.UserID,
+					Timestamp: unixMillis(),
 				})
+				return nil
+			}, database, notification); err != nil {
+				http.Error(w, "could not pin message", http.StatusInternalServerError)
+				return
+			}
+
+			WriteJSON(w, nil)
+		case http.MethodDelete:
+			if !group.IsPinned(messageID) {
+				http.Error(w, "message not pinned", http.StatusNotFound)
+				return
+			}
+
+			if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+				group.Pins = slices.DeleteFunc(slices.Clone(group.Pins), func(pin Pin) bool { return pin.MessageID == messageID })
+				return nil
+			}, database, notification); err != nil {
+				http.Error(w, "could not unpin message", http.StatusInternalServerError)
+				return
 			}
-			chat.Continue = more
 
-			json.NewEncoder(w).Encode(chat)
+			WriteJSON(w, nil)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(UserKey).(*User)
+		group := r.Context().Value(GroupKey).(*Group)
+
+		if !group.IsMember(user.UserID) {
+			http.Error(w, "not a member of group", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeChatPage(w, r, group.GroupID, 0, database)
 		case http.MethodPatch:
 			var request PatchChatRequest
 			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -87,33 +561,520 @@ func RestGroupChatAPI(router *mux.Router, database Database, notification Notifi
 				return
 			}
 
+			var parent *Message
+			if request.ParentID != 0 {
+				var ok bool
+				if parent, ok = readChatMessage(w, group.GroupID, request.ParentID, database); !ok {
+					return
+				}
+				if parent.ParentID != 0 {
+					http.Error(w, "cannot reply to a reply", http.StatusBadRequest)
+					return
+				}
+			}
+
+			// Commands act on the group, and are still sent so that their
+			// result can be posted in reply.
+			var result *chatCommandResult
+			if command, args := parseChatCommand(request.Content); command != nil && parent == nil {
+				commandResult, ok := command(w, args, group, user, database, notification, scheduler)
+				if !ok {
+					return
+				}
+				result = &commandResult
+			}
+
+			members := readMembers(group, database)
 			message := Message{
 				GroupID:   group.GroupID,
 				Sender:    user.UserID,
 				Timestamp: unixMillis(),
 				Content:   censor(request.Content),
+				ParentID:  request.ParentID,
 			}
-			if err := database.CreateMessage(message); err != nil {
-				http.Error(w, fmt.Sprintf("could not create message: %v", err), http.StatusInternalServerError)
+			message.Mentions = parseMentions(message.Content, members)
+			message.Expiry = group.MessageExpiry(message.Timestamp)
+			if err := createMessage(&message, database); err != nil {
+				if result == nil {
+					http.Error(w, fmt.Sprintf("could not create message: %v", err), http.StatusInternalServerError)
+					return
+				}
+				// The command already acted on the group, so post its result
+				// on its own, rather than failing (and being retried).
+				log.Printf("could not create command message in group %d: %v\n", group.GroupID, err)
+				postSystemMessage(group, result.Kind, user.UserID, result.Subject, database, notification)
+				WriteJSON(w, PatchChatResponse{})
 				return
 			}
 
+			if parent != nil {
+				countReply(group.GroupID, parent.MessageID, message.Timestamp, database)
+			}
+
 			notifyGroup(group, MessageReceived{Message: MessageReceivedMessage{
 				GroupID:   message.GroupID,
+				MessageID: message.MessageID,
 				Timestamp: message.Timestamp,
 				Sender:    message.Sender,
 				Content:   message.Content,
+				ParentID:  message.ParentID,
+				Mentions:  message.Mentions,
 			}}, database, notification)
-			pushGroup(group, MessagePushed{
-				Message: MessagePushedMessage{
-					Group:     group.Name,
-					Timestamp: message.Timestamp,
-					Sender:    user.Name,
-					Content:   message.Content,
-				},
-			}, database)
+			pushChatMessage(group, members, message, user.Name, parent, database)
+			if result != nil {
+				postSystemReply(group, message.MessageID, result.Kind, user.UserID, result.Subject, database, notification)
+			}
+
+			WriteJSON(w, PatchChatResponse{
+				MessageID: message.MessageID,
+			})
+		case http.MethodDelete:
+			if !group.IsAdmin(user.UserID) {
+				http.Error(w, "must be group admin", http.StatusUnauthorized)
+				return
+			}
+
+			// Purges messages sent up to the Unix millisecond time, or all of them.
+			endTime, err := strconv.ParseUint(r.URL.Query().Get("end"), 10, 64)
+			if err != nil {
+				endTime = math.MaxUint64
+			}
+			if err := clearChat(group, lastMessageID(endTime), database, notification); err != nil {
+				http.Error(w, "could not purge chat", http.StatusInternalServerError)
+				return
+			}
 
 			WriteJSON(w, nil)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
 }
+
+// Helper to parse the pagination query parameters of chat, writing an error
+// if they are invalid.
+func parseChatPage(w http.ResponseWriter, r *http.Request) (int, chatCursor, bool) {
+	query := r.URL.Query()
+
+	limit := chatDefaultLimit
+	if limitString := query.Get("limit"); limitString != "" {
+		parsed, err := strconv.Atoi(limitString)
+		if err != nil || parsed < 1 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return 0, chatCursor{}, false
+		}
+		limit = min(parsed, chatMaxLimit)
+	}
+
+	var cursor chatCursor
+	if cursorString := query.Get("cursor"); cursorString != "" {
+		if !decodeChatCursor(cursorString, &cursor) {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return 0, cursor, false
+		}
+	} else {
+		startTime, err := strconv.ParseUint(query.Get("start"), 10, 64)
+		if err != nil {
+			startTime = 0
+		}
+		endTime, err := strconv.ParseUint(query.Get("end"), 10, 64)
+		if err != nil {
+			endTime = math.MaxUint64
+		}
+		cursor = chatCursor{
+			Start:   firstMessageID(startTime),
+			End:     lastMessageID(endTime),
+			Forward: query.Get("direction") == "forward",
+		}
+	}
+	return limit, cursor, true
+}
+
+// Helper to write a page of chat in the group (or in the thread of
+// `parentID`, if it isn't 0) according to the pagination query parameters.
+func writeChatPage(w http.ResponseWriter, r *http.Request, groupID GroupID, parentID MessageID, database Database) {
+	limit, cursor, ok := parseChatPage(w, r)
+	if !ok {
+		return
+	}
+
+	if parentID != 0 {
+		// Replies are always sent after the message they reply to.
+		cursor.Start = max(cursor.Start, parentID+1)
+	}
+	if cursor.Start > cursor.End {
+		WriteJSON(w, GetChatResponse{Messages: []GetChatResponseMessage{}})
+		return
+	}
+
+	system := r.URL.Query().Get("system") != "false"
+	messages, more, err := database.ReadMessages(groupID, parentID, cursor.Start, cursor.End, limit, cursor.Forward, system)
+	if err != nil {
+		http.Error(w, "could not read chat", http.StatusInternalServerError)
+		return
+	}
+
+	var chat GetChatResponse
+
+	chat.Messages = []GetChatResponseMessage{}
+	for _, message := range messages {
+		chat.Messages = append(chat.Messages, GetChatResponseMessage{
+			MessageID:  message.MessageID,
+			Sender:     message.Sender,
+			Timestamp:  message.Timestamp,
+			Content:    message.Content,
+			Kind:       message.Kind,
+			EditedAt:   message.EditedAt,
+			Deleted:    message.Deleted,
+			Reactions:  message.Reactions,
+			ParentID:   message.ParentID,
+			ReplyCount: message.ReplyCount,
+			LastReply:  message.LastReply,
+			Mentions:   message.Mentions,
+		})
+	}
+	if more {
+		// Continue just past the messages already returned.
+		if cursor.Forward {
+			cursor.Start = messages[len(messages)-1].MessageID + 1
+		} else {
+			cursor.End = messages[0].MessageID - 1
+		}
+		chat.Continue = encodeChatCursor(cursor)
+	}
+
+	WriteJSON(w, chat)
+}
+
+// Helper to create a chat message with a new ID.
+func createMessage(message *Message, database Database) error {
+	var err error
+	for attempt := 0; attempt < chatCreateAttempts; attempt++ {
+		// Another instance may have sent a message in the same millisecond.
+		message.MessageID = newMessageID(message.Timestamp)
+		if err = database.CreateMessage(*message); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// Helper to count a reply in the thread of a chat message.
+//
+// Errors are logged, as the reply itself has already been sent.
+func countReply(groupID GroupID, parentID MessageID, timestamp UnixMillis, database Database) {
+	if err := database.UpdateMessage(groupID, parentID, func(parent *Message) error {
+		parent.ReplyCount++
+		parent.LastReply = max(parent.LastReply, timestamp)
+		return nil
+	}); err != nil {
+		log.Printf("could not count reply to message %d: %v\n", parentID, err)
+	}
+}
+
+// Records a group event in the chat, attributed to the member that caused it,
+// and delivers it to members (without a push notification).
+//
+// Errors are logged, as the event itself has already happened.
+func postSystemMessage(group *Group, kind string, sender UserID, subject string, database Database, notification Notification) {
+	postSystemReply(group, 0, kind, sender, subject, database, notification)
+}
+
+// Like `postSystemMessage`, but in the thread of message `parentID` (unless it
+// is 0).
+func postSystemReply(group *Group, parentID MessageID, kind string, sender UserID, subject string, database Database, notification Notification) {
+	message := Message{
+		GroupID:   group.GroupID,
+		Sender:    sender,
+		Timestamp: unixMillis(),
+		Content:   censor(subject),
+		Kind:      kind,
+		ParentID:  parentID,
+	}
+	message.Expiry = group.MessageExpiry(message.Timestamp)
+	if err := createMessage(&message, database); err != nil {
+		log.Printf("could not post %s message in group %d: %v\n", kind, group.GroupID, err)
+		return
+	}
+	if parentID != 0 {
+		countReply(group.GroupID, parentID, message.Timestamp, database)
+	}
+	notifyGroup(group, MessageReceived{Message: MessageReceivedMessage{
+		GroupID:   message.GroupID,
+		MessageID: message.MessageID,
+		Timestamp: message.Timestamp,
+		Sender:    message.Sender,
+		Content:   message.Content,
+		ParentID:  message.ParentID,
+		Kind:      message.Kind,
+	}}, database, notification)
+}
+
+// Finds the members mentioned in a chat message, like "@Alex". Names are
+// matched case-insensitively, preferring the longest (so "@Alex Smith" mentions
+// only "Alex Smith" if there is also an "Alex").
+func parseMentions(content string, members []User) []UserID {
+	var mentions []UserID
+	content = strings.ToLower(content)
+	for i, r := range content {
+		if r != '@' {
+			continue
+		}
+		if before, _ := utf8.DecodeLastRuneInString(content[:i]); i > 0 && isNameRune(before) {
+			// Part of a word, like an email address.
+			continue
+		}
+		rest := content[i+1:]
+		var mentioned *UserID
+		longest := 0
+		for j := range members {
+			name := strings.ToLower(members[j].Name)
+			if len(name) <= longest || !strings.HasPrefix(rest, name) {
+				continue
+			}
+			if after, _ := utf8.DecodeRuneInString(rest[len(name):]); len(rest) > len(name) && isNameRune(after) {
+				// Only a prefix of a longer word.
+				continue
+			}
+			mentioned = &members[j].UserID
+			longest = len(name)
+		}
+		if mentioned != nil && !slices.Contains(mentions, *mentioned) {
+			mentions = append(mentions, *mentioned)
+		}
+	}
+	return mentions
+}
+
+func isNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// Excerpts a chat message around the first of its words in `terms`, splitting
+// it so those words can be highlighted.
+func chatSnippet(content string, terms []string) []GetChatSearchResponseSnippet {
+	runes := []rune(content)
+
+	// Find the words to highlight, as ranges of runes.
+	var matches [][2]int
+	wordStart := -1
+	for i := 0; i <= len(runes); i++ {
+		if i < len(runes) && isSearchRune(runes[i]) {
+			if wordStart < 0 {
+				wordStart = i
+			}
+			continue
+		}
+		if wordStart >= 0 && slices.Contains(terms, strings.ToLower(string(runes[wordStart:i]))) {
+			matches = append(matches, [2]int{wordStart, i})
+		}
+		wordStart = -1
+	}
+
+	from, to := 0, len(runes)
+	if len(runes) > chatSnippetLen {
+		if len(matches) > 0 {
+			from = min(max(matches[0][0]-chatSnippetLead, 0), len(runes)-chatSnippetLen)
+		}
+		to = from + chatSnippetLen
+	}
+
+	snippet := []GetChatSearchResponseSnippet{}
+	add := func(text string, match bool) {
+		if text != "" {
+			snippet = append(snippet, GetChatSearchResponseSnippet{Text: text, Match: match})
+		}
+	}
+	position := from
+	for _, match := range matches {
+		if match[1] <= from || match[0] >= to {
+			continue
+		}
+		start, end := max(match[0], from), min(match[1], to)
+		add(string(runes[position:start]), false)
+		add(string(runes[start:end]), true)
+		position = end
+	}
+	add(string(runes[position:to]), false)
+
+	// Show where the message was cut.
+	if from > 0 {
+		snippet = slices.Insert(snippet, 0, GetChatSearchResponseSnippet{Text: "…"})
+	}
+	if to < len(runes) {
+		snippet = append(snippet, GetChatSearchResponseSnippet{Text: "…"})
+	}
+	return snippet
+}
+
+// Sends best-effort push notifications of a new chat message.
+//
+// Mentioned members are pushed urgently. Otherwise, a reply is only pushed to
+// the author of its parent, and members may opt out of pushes that don't
+// mention them.
+func pushChatMessage(group *Group, members []User, message Message, senderName string, parent *Message, database Database) {
+	var wait sync.WaitGroup
+	for _, member := range members {
+		member := member
+		data := chatMessagePush(group, &member, message, senderName, parent)
+		if data == nil {
+			continue
+		}
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			pushSubscriptions(&member, data, database)
+		}()
+	}
+	wait.Wait()
+}
+
+// Returns what to push to a member about a chat message, or nil if nothing.
+func chatMessagePush(group *Group, member *User, message Message, senderName string, parent *Message) any {
+	if slices.Contains(message.Mentions, member.UserID) {
+		return MentionPushed{
+			Mention: MentionPushedMention{
+				Group:     group.Name,
+				Timestamp: message.Timestamp,
+				Sender:    senderName,
+				Content:   message.Content,
+			},
+		}
+	}
+	if !member.PushMentionsOnly && (parent == nil || (member.UserID == parent.Sender && member.UserID != message.Sender)) {
+		return MessagePushed{
+			Message: MessagePushedMessage{
+				Group:     group.Name,
+				Timestamp: message.Timestamp,
+				Sender:    senderName,
+				Content:   message.Content,
+			},
+		}
+	}
+	return nil
+}
+
+// Counts the unread messages in each of a user's groups, up to `chatMaxUnread`
+// per group. Groups that can't be read are skipped.
+func unreadCounts(user *User, database Database) []GetUserResponseUnread {
+	unread := []GetUserResponseUnread{}
+	for _, groupID := range user.Groups {
+		lastRead := user.LastRead(groupID)
+		if lastRead == math.MaxUint64 {
+			continue
+		}
+		count, more, mentioned, err := countUnread(user, groupID, lastRead, database)
+		if err != nil || count == 0 {
+			continue
+		}
+		unread = append(unread, GetUserResponseUnread{
+			GroupID:   groupID,
+			Count:     count,
+			More:      more,
+			Mentioned: mentioned,
+		})
+	}
+	return unread
+}
+
+// Helper to count the latest chat messages in a group after `lastRead`, up to
+// `chatMaxUnread`, other than the user's own (which they have seen). Also
+// returns whether there are more than that, and whether any of those counted
+// mention the user.
+func countUnread(user *User, groupID GroupID, lastRead MessageID, database Database) (int, bool, bool, error) {
+	count := 0
+	mentioned := false
+	end := MessageID(math.MaxUint64)
+	for {
+		messages, more, err := database.ReadMessages(groupID, 0, lastRead+1, end, chatMaxUnread, false, false)
+		if err != nil {
+			return 0, false, false, err
+		}
+		// From latest to earliest.
+		for i := len(messages) - 1; i >= 0; i-- {
+			if messages[i].Sender == user.UserID {
+				continue
+			}
+			if count == chatMaxUnread {
+				return count, true, mentioned, nil
+			}
+			count++
+			mentioned = mentioned || slices.Contains(messages[i].Mentions, user.UserID)
+		}
+		if !more {
+			return count, false, mentioned, nil
+		}
+		end = messages[0].MessageID - 1
+	}
+}
+
+// Helper to get the latest chat message the user has read in a group, or 0
+// if none.
+func (user *User) LastRead(groupID GroupID) MessageID {
+	for _, position := range user.ReadPositions {
+		if position.GroupID == groupID {
+			return position.MessageID
+		}
+	}
+	return 0
+}
+
+// Helper to read a chat message that hasn't been deleted, writing an HTTP error
+// and returning false if there is no such message.
+func readChatMessage(w http.ResponseWriter, groupID GroupID, messageID MessageID, database Database) (*Message, bool) {
+	message, err := database.ReadMessage(groupID, messageID)
+	if err != nil {
+		http.Error(w, "could not read message", http.StatusInternalServerError)
+		return nil, false
+	}
+	if message == nil || message.Deleted {
+		http.Error(w, "message not found", http.StatusNotFound)
+		return nil, false
+	}
+	return message, true
+}
+
+// Encodes a chat position as an opaque string.
+func encodeChatCursor(cursor chatCursor) string {
+	return base64.RawURLEncoding.EncodeToString(mustMarshal(cursor))
+}
+
+// Decodes a chat position, returning false if the string is invalid.
+func decodeChatCursor(encoded string, cursor *chatCursor) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(decoded, cursor) == nil && cursor.Start <= cursor.End
+}
+
+// The most recent message ID generated by this instance.
+var latestMessageID atomic.Uint64
+
+// Generates an ID for a message sent at the given time, greater than any
+// generated before by this instance. Other instances may generate the same ID.
+func newMessageID(timestamp UnixMillis) MessageID {
+	for {
+		latest := latestMessageID.Load()
+		messageID := max(firstMessageID(timestamp), latest+1)
+		if latestMessageID.CompareAndSwap(latest, messageID) {
+			return messageID
+		}
+	}
+}
+
+// Returns the lowest ID a message sent at the given time could have.
+func firstMessageID(timestamp UnixMillis) MessageID {
+	if timestamp > math.MaxUint64/messageIDsPerMillisecond {
+		return math.MaxUint64
+	}
+	return timestamp * messageIDsPerMillisecond
+}
+
+// Returns the highest ID a message sent at the given time could have.
+func lastMessageID(timestamp UnixMillis) MessageID {
+	if timestamp >= math.MaxUint64/messageIDsPerMillisecond {
+		return math.MaxUint64
+	}
+	return timestamp*messageIDsPerMillisecond + messageIDsPerMillisecond - 1
+}
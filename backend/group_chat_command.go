@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// The system reply that records the result of a chat command.
+type chatCommandResult struct {
+	Kind    string
+	Subject string
+}
+
+// A chat command, like "/poll", that acts on the group via the same path as the
+// corresponding API.
+//
+// If returns false, error has been sent and should return.
+type chatCommand func(w http.ResponseWriter, args string, group *Group, user *User, database Database, notification Notification, scheduler Scheduler) (chatCommandResult, bool)
+
+// Chat commands by name.
+var chatCommands = map[string]chatCommand{
+	// Like `/poll "Dinner?" pizza tacos`.
+	"poll": func(w http.ResponseWriter, args string, group *Group, user *User, database Database, notification Notification, scheduler Scheduler) (chatCommandResult, bool) {
+		fields, ok := commandFields(args)
+		if !ok || len(fields) == 0 {
+			http.Error(w, `usage: /poll "title" option...`, http.StatusBadRequest)
+			return chatCommandResult{}, false
+		}
+		poll, ok := createPoll(w, group, user, PatchPollsRequest{Title: fields[0], Options: fields[1:]}, database, notification)
+		if !ok {
+			return chatCommandResult{}, false
+		}
+		return chatCommandResult{Kind: MessageKindPollCreated, Subject: poll.Title}, true
+	},
+	// Like `/task buy snacks @alex`, assigned to the user unless it ends with a
+	// mention.
+	"task": func(w http.ResponseWriter, args string, group *Group, user *User, database Database, notification Notification, scheduler Scheduler) (chatCommandResult, bool) {
+		title, assignee, ok := cutAssignee(args, readMembers(group, database))
+		if !ok {
+			http.Error(w, "assignee not in group", http.StatusBadRequest)
+			return chatCommandResult{}, false
+		}
+		task, ok := createTask(w, group, user, PatchTaskRequest{Title: title, Assignee: assignee}, database, notification, scheduler)
+		if !ok {
+			return chatCommandResult{}, false
+		}
+		return chatCommandResult{Kind: MessageKindTaskCreated, Subject: task.Title}, true
+	},
+	// Like `/activity 2024-05-01 18:00-20:00 Movie night`.
+	"activity": func(w http.ResponseWriter, args string, group *Group, user *User, database Database, notification Notification, scheduler Scheduler) (chatCommandResult, bool) {
+		fields := strings.Fields(args)
+		if len(fields) < 3 {
+			http.Error(w, "usage: /activity date start-end title", http.StatusBadRequest)
+			return chatCommandResult{}, false
+		}
+		start, end, _ := strings.Cut(fields[1], "-")
+		request := PatchActivityRequest{Title: strings.Join(fields[2:], " "), Date: fields[0], Start: start, End: end}
+		activity, ok := createActivity(w, group, user, request, database, notification, scheduler)
+		if !ok {
+			return chatCommandResult{}, false
+		}
+		return chatCommandResult{Kind: MessageKindActivityCreated, Subject: activity.Title}, true
+	},
+	// Like `/available 2024-05-01 18:00-20:00`.
+	"available": func(w http.ResponseWriter, args string, group *Group, user *User, database Database, notification Notification, scheduler Scheduler) (chatCommandResult, bool) {
+		fields := strings.Fields(args)
+		if len(fields) != 2 {
+			http.Error(w, "usage: /available date start-end", http.StatusBadRequest)
+			return chatCommandResult{}, false
+		}
+		start, end, _ := strings.Cut(fields[1], "-")
+		request := PatchAvailabilityRequest{Date: fields[0], Start: start, End: end}
+		if !createAvailability(w, group, user, request, database, notification) {
+			return chatCommandResult{}, false
+		}
+		return chatCommandResult{Kind: MessageKindAvailabilityAdded, Subject: fields[0] + " " + fields[1]}, true
+	},
+}
+
+// Finds the chat command a message starts with, like "/poll", and its
+// arguments. Returns nil if there is none, so the message is just sent.
+func parseChatCommand(content string) (chatCommand, string) {
+	name, ok := strings.CutPrefix(content, "/")
+	if !ok {
+		return nil, ""
+	}
+	name, args, _ := strings.Cut(name, " ")
+	return chatCommands[name], strings.TrimSpace(args)
+}
+
+// Splits chat command arguments on whitespace, except within double quotes
+// (which are removed), like `"Dinner?" pizza tacos`. Returns false if a quote
+// isn't closed.
+func commandFields(args string) ([]string, bool) {
+	var fields []string
+	var field strings.Builder
+	// Whether a field is in progress, even if it is empty (like `""`).
+	inField := false
+	inQuotes := false
+	for _, r := range args {
+		switch {
+		case r == '"' || r == '“' || r == '”':
+			inQuotes = !inQuotes
+			inField = true
+		case unicode.IsSpace(r) && !inQuotes:
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, !inQuotes
+}
+
+// Splits a mention of a member, like "@Alex", off the end of a task title.
+// Returns a nil assignee if there is no mention, or false if the mention isn't
+// of a member.
+func cutAssignee(text string, members []User) (string, *UserID, bool) {
+	i := strings.LastIndex(text, "@")
+	if i < 0 || (i > 0 && !unicode.IsSpace(rune(text[i-1]))) {
+		return text, nil, true
+	}
+	name := strings.TrimSpace(text[i+1:])
+	for j := range members {
+		if strings.EqualFold(members[j].Name, name) {
+			return strings.TrimSpace(text[:i]), &members[j].UserID, true
+		}
+	}
+	return text, nil, false
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	groupMaxInvites = 16
+)
+
+// Reasons a user may not join a group, which may only be found in the
+// transaction if they changed in the meantime.
+var (
+	errBannedFromGroup = errors.New("banned from group")
+	errInvalidInvite   = errors.New("invalid or expired invite")
+)
+
+// Invite sent over JSON.
+type GetInviteResponse struct {
+	Token   string     `json:"token"`
+	Creator UserID     `json:"creator"`
+	Expiry  UnixMillis `json:"expiry"`
+	MaxUses uint64     `json:"maxUses"`
+	Uses    uint64     `json:"uses"`
+}
+
+// New invite sent over JSON.
+type PatchInviteRequest struct {
+	// Unix millisecond time after which the invite is invalid, or 0 for never.
+	Expiry UnixMillis `json:"expiry"`
+	// Maximum number of times the invite may be redeemed, or 0 for unlimited.
+	MaxUses uint64 `json:"maxUses"`
+}
+
+// New invite token sent over JSON.
+type PatchInviteResponse struct {
+	Token string `json:"token"`
+}
+
+// Invite redemption sent over JSON.
+type PatchJoinRequest struct {
+	Token string `json:"token"`
+}
+
+// API's related to invites within a group.
+func RestGroupInviteAPI(router *mux.Router, database Database, notification Notification) {
+	router.HandleFunc("/{token}/", func(w http.ResponseWriter, r *http.Request) {
+		token := mux.Vars(r)["token"]
+
+		user := r.Context().Value(UserKey).(*User)
+		group := r.Context().Value(GroupKey).(*Group)
+
+		if !group.IsMember(user.UserID) {
+			http.Error(w, "not a member of group", http.StatusUnauthorized)
+			return
+		}
+
+		invite := group.FindInvite(token)
+		if invite == nil {
+			http.Error(w, "invite not found", http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodDelete:
+			if invite.Creator != user.UserID && !group.IsAdmin(user.UserID) {
+				http.Error(w, "must be invite creator or group admin", http.StatusUnauthorized)
+				return
+			}
+			if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+				group.Invites = slices.DeleteFunc(slices.Clone(group.Invites), func(invite Invite) bool {
+					return invite.Token == token
+				})
+				return nil
+			}, database, notification); err != nil {
+				http.Error(w, "could not revoke invite", http.StatusInternalServerError)
+				return
+			}
+			WriteJSON(w, nil)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(UserKey).(*User)
+		group := r.Context().Value(GroupKey).(*Group)
+
+		if !group.IsMember(user.UserID) {
+			http.Error(w, "not a member of group", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			now := unixMillis()
+			response := []GetInviteResponse{}
+			for _, invite := range group.Invites {
+				if !invite.IsValid(now) {
+					continue
+				}
+				response = append(response, GetInviteResponse{
+					Token:   invite.Token,
+					Creator: invite.Creator,
+					Expiry:  invite.Expiry,
+					MaxUses: invite.MaxUses,
+					Uses:    invite.Uses,
+				})
+			}
+			WriteJSON(w, response)
+		case http.MethodPatch:
+			var request PatchInviteRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "could not decode body", http.StatusBadRequest)
+				return
+			}
+
+			now := unixMillis()
+			if request.Expiry != 0 && request.Expiry <= now {
+				http.Error(w, "expiry in the past", http.StatusBadRequest)
+				return
+			}
+
+			// Invites that can no longer be used will be forgotten to make room.
+			valid := slices.DeleteFunc(slices.Clone(group.Invites), func(invite Invite) bool {
+				return !invite.IsValid(now)
+			})
+			if invalidAppend(w, valid, groupMaxInvites) {
+				return
+			}
+
+			token, err := generateToken()
+			if err != nil {
+				http.Error(w, "could not generate invite", http.StatusInternalServerError)
+				return
+			}
+
+			if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+				// Make room by forgetting invites that can no longer be used.
+				group.Invites = slices.DeleteFunc(slices.Clone(group.Invites), func(invite Invite) bool {
+					return !invite.IsValid(now)
+				})
+				if len(group.Invites) >= groupMaxInvites {
+					return fmt.Errorf("too many invites")
+				}
+				group.Invites = append(group.Invites, Invite{
+					Token:   token,
+					Creator: user.UserID,
+					Expiry:  request.Expiry,
+					MaxUses: request.MaxUses,
+				})
+				return nil
+			}, database, notification); err != nil {
+				http.Error(w, "could not create invite", http.StatusInternalServerError)
+				return
+			}
+
+			WriteJSON(w, PatchInviteResponse{
+				Token: token,
+			})
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// API for redeeming an invite to a group.
+func RestGroupJoinAPI(router *mux.Router, database Database, notification Notification) {
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user := r.Context().Value(UserKey).(*User)
+		group := r.Context().Value(GroupKey).(*Group)
+
+		var request PatchJoinRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "could not decode body", http.StatusBadRequest)
+			return
+		}
+
+		if group.IsMember(user.UserID) {
+			// Nothing to do, and don't spend a use of the invite.
+			WriteJSON(w, nil)
+			return
+		}
+
+		if slices.Contains(group.Banned, user.UserID) {
+			http.Error(w, errBannedFromGroup.Error(), http.StatusForbidden)
+			return
+		}
+
+		now := unixMillis()
+		if invite := group.FindInvite(request.Token); invite == nil || !invite.IsValid(now) {
+			http.Error(w, errInvalidInvite.Error(), http.StatusForbidden)
+			return
+		}
+
+		if invalidAppend(w, user.Groups, maxGroupsPerUser) {
+			return
+		}
+
+		var joined *Group
+		if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+			joined = nil
+			if group.IsMember(user.UserID) {
+				return nil
+			}
+			if slices.Contains(group.Banned, user.UserID) {
+				return errBannedFromGroup
+			}
+			group.Invites = slices.Clone(group.Invites)
+			invite := group.FindInvite(request.Token)
+			if invite == nil || !invite.IsValid(now) {
+				return errInvalidInvite
+			}
+			invite.Uses += 1
+			group.Members = append(slices.Clip(group.Members), user.UserID)
+			joined = group
+			return nil
+		}, database, notification); err != nil {
+			if errors.Is(err, errBannedFromGroup) || errors.Is(err, errInvalidInvite) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			http.Error(w, "could not join group (part 1)", http.StatusInternalServerError)
+			return
+		}
+		if err := database.UpdateUser(user.UserID, func(user *User) error {
+			if !slices.Contains(user.Groups, group.GroupID) {
+				user.Groups = append(user.Groups, group.GroupID)
+			}
+			return nil
+		}); err != nil {
+			http.Error(w, "could not join group (part 2)", http.StatusInternalServerError)
+			return
+		}
+
+		if joined != nil {
+			// Deliver to the new member too.
+			postSystemMessage(joined, MessageKindMemberJoined, user.UserID, "", database, notification)
+		}
+
+		WriteJSON(w, nil)
+	})
+}
+
+// Helper to find an invite by token, returning nil if there is none.
+func (group *Group) FindInvite(token string) *Invite {
+	for i := range group.Invites {
+		if group.Invites[i].Token == token {
+			return &group.Invites[i]
+		}
+	}
+	return nil
+}
+
+// Helper to check if an invite may still be redeemed at the given time.
+func (invite *Invite) IsValid(now UnixMillis) bool {
+	if invite.Expiry != 0 && now >= invite.Expiry {
+		return false
+	}
+	if invite.MaxUses != 0 && invite.Uses >= invite.MaxUses {
+		return false
+	}
+	return true
+}
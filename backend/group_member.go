@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gorilla/mux"
+)
+
+type Role = string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+)
+
+// Role change sent over JSON.
+type PatchMemberRequest struct {
+	Role Role `json:"role"`
+}
+
+// API's related to managing members within a group.
+func RestGroupMemberAPI(router *mux.Router, database Database, notification Notification) {
+	router.HandleFunc("/{memberID}/", func(w http.ResponseWriter, r *http.Request) {
+		memberID, ok := ParseUint64PathParameter(w, r, "memberID")
+		if !ok {
+			return
+		}
+
+		user := r.Context().Value(UserKey).(*User)
+		group := r.Context().Value(GroupKey).(*Group)
+
+		if !group.IsMember(user.UserID) {
+			http.Error(w, "not a member of group", http.StatusUnauthorized)
+			return
+		}
+
+		if !group.IsMember(memberID) {
+			http.Error(w, "member not found", http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPatch:
+			var request PatchMemberRequest
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				http.Error(w, "could not decode body", http.StatusBadRequest)
+				return
+			}
+
+			if request.Role != RoleOwner && request.Role != RoleAdmin && request.Role != RoleMember {
+				http.Error(w, "invalid role", http.StatusBadRequest)
+				return
+			}
+			if group.RoleOf(user.UserID) != RoleOwner {
+				http.Error(w, "must be group owner", http.StatusUnauthorized)
+				return
+			}
+			if memberID == user.UserID {
+				http.Error(w, "cannot change own role (transfer ownership instead)", http.StatusBadRequest)
+				return
+			}
+
+			if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+				if group.RoleOf(user.UserID) != RoleOwner {
+					return fmt.Errorf("no longer group owner")
+				}
+				group.Admins = slices.DeleteFunc(group.Admins, func(admin UserID) bool {
+					return admin == memberID
+				})
+				switch request.Role {
+				case RoleOwner:
+					// The previous owner remains an admin.
+					group.Owner = memberID
+					group.Admins = append(group.Admins, user.UserID)
+				case RoleAdmin:
+					group.Admins = append(group.Admins, memberID)
+				}
+				return nil
+			}, database, notification); err != nil {
+				http.Error(w, "could not change role", http.StatusInternalServerError)
+				return
+			}
+
+			WriteJSON(w, nil)
+		case http.MethodDelete:
+			ban := r.URL.Query().Get("ban") == "true"
+
+			if memberID == user.UserID {
+				http.Error(w, "cannot remove self (leave instead)", http.StatusBadRequest)
+				return
+			}
+			if !group.mayRemove(user.UserID, memberID) {
+				http.Error(w, "insufficient role to remove member", http.StatusUnauthorized)
+				return
+			}
+
+			if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+				if !group.mayRemove(user.UserID, memberID) {
+					return fmt.Errorf("insufficient role to remove member")
+				}
+				group.removeMember(memberID)
+				if ban && !slices.Contains(group.Banned, memberID) {
+					group.Banned = append(group.Banned, memberID)
+				}
+				return nil
+			}, database, notification); err != nil {
+				http.Error(w, "could not remove member (part 1)", http.StatusInternalServerError)
+				return
+			}
+			if err := database.UpdateUser(memberID, func(user *User) error {
+				user.Groups = slices.DeleteFunc(user.Groups, func(groupID GroupID) bool { return groupID == group.GroupID })
+				return nil
+			}); err != nil {
+				http.Error(w, "could not remove member (part 2)", http.StatusInternalServerError)
+				return
+			}
+
+			notifyUser(memberID, MemberRemoved{
+				Removed: MemberRemovedGroup{
+					GroupID: group.GroupID,
+					Banned:  ban,
+				},
+			}, database, notification)
+
+			WriteJSON(w, nil)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// API's related to users banned from a group.
+func RestGroupBanAPI(router *mux.Router, database Database, notification Notification) {
+	router.HandleFunc("/{bannedID}/", func(w http.ResponseWriter, r *http.Request) {
+		bannedID, ok := ParseUint64PathParameter(w, r, "bannedID")
+		if !ok {
+			return
+		}
+
+		user := r.Context().Value(UserKey).(*User)
+		group := r.Context().Value(GroupKey).(*Group)
+
+		if !group.IsAdmin(user.UserID) {
+			http.Error(w, "must be group admin", http.StatusUnauthorized)
+			return
+		}
+
+		if !slices.Contains(group.Banned, bannedID) {
+			http.Error(w, "ban not found", http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodDelete:
+			if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+				group.Banned = slices.DeleteFunc(group.Banned, func(banned UserID) bool {
+					return banned == bannedID
+				})
+				return nil
+			}, database, notification); err != nil {
+				http.Error(w, "could not un-ban user", http.StatusInternalServerError)
+				return
+			}
+			WriteJSON(w, nil)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		user := r.Context().Value(UserKey).(*User)
+		group := r.Context().Value(GroupKey).(*Group)
+
+		if !group.IsAdmin(user.UserID) {
+			http.Error(w, "must be group admin", http.StatusUnauthorized)
+			return
+		}
+
+		WriteJSON(w, append([]UserID{}, group.Banned...))
+	})
+}
+
+// Helper to get the role of a user within a group, or "" if not a member.
+func (group *Group) RoleOf(userID UserID) Role {
+	if !group.IsMember(userID) {
+		return ""
+	}
+	if group.Owner == userID {
+		return RoleOwner
+	}
+	if slices.Contains(group.Admins, userID) {
+		return RoleAdmin
+	}
+	return RoleMember
+}
+
+// Helper to check if a user is an owner or admin of a group.
+func (group *Group) IsAdmin(userID UserID) bool {
+	role := group.RoleOf(userID)
+	return role == RoleOwner || role == RoleAdmin
+}
+
+// Helper to check if a user may remove a member. Admins may remove plain
+// members, and only the owner may remove admins. Nobody may remove the owner.
+func (group *Group) mayRemove(userID UserID, memberID UserID) bool {
+	switch group.RoleOf(memberID) {
+	case RoleMember:
+		return group.IsAdmin(userID)
+	case RoleAdmin:
+		return group.RoleOf(userID) == RoleOwner
+	default:
+		return false
+	}
+}
+
+// Helper to remove a member along with their availabilities, confirmations,
+// votes, tasks, and role.
+func (group *Group) removeMember(userID UserID) {
+	group.migratePoll()
+	group.Members = slices.DeleteFunc(group.Members, func(member UserID) bool {
+		return member == userID
+	})
+	group.Admins = slices.DeleteFunc(group.Admins, func(admin UserID) bool {
+		return admin == userID
+	})
+	group.ensureOwner()
+	group.Availabilities = slices.DeleteFunc(group.Availabilities, func(availability Availability) bool {
+		return availability.UserID == userID
+	})
+	for i := range group.Activities {
+		activity := &group.Activities[i]
+		activity.Confirmed = slices.DeleteFunc(activity.Confirmed, func(confirmed UserID) bool {
+			return confirmed == userID
+		})
+	}
+	// TODO: suboptimal. ideally tasks could be reverted to
+	// no assignee, but that complicates the protocol.
+	group.Tasks = slices.DeleteFunc(group.Tasks, func(task Task) bool {
+		return task.Assignee == userID
+	})
+	// Closed polls keep their results.
+	for i := range group.Polls {
+		poll := &group.Polls[i]
+		if poll.Closed {
+			continue
+		}
+		for j := range poll.Options {
+			option := &poll.Options[j]
+			option.Votes = slices.DeleteFunc(option.Votes, func(vote UserID) bool {
+				return vote == userID
+			})
+		}
+	}
+}
+
+// Helper to hand the group's ownership to a member, preferring admins, if the
+// owner is no longer a member or the group was created before roles existed.
+func (group *Group) ensureOwner() {
+	if group.Owner != 0 && group.IsMember(group.Owner) {
+		return
+	}
+	group.Owner = 0
+	for _, admin := range group.Admins {
+		if group.IsMember(admin) {
+			group.Owner = admin
+			break
+		}
+	}
+	if group.Owner == 0 && len(group.Members) > 0 {
+		group.Owner = group.Members[0]
+	}
+	group.Admins = slices.DeleteFunc(slices.Clone(group.Admins), func(admin UserID) bool {
+		return admin == group.Owner || !group.IsMember(admin)
+	})
+}
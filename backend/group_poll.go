@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"slices"
@@ -13,22 +14,41 @@ const (
 	pollTitleMinLen = 1
 	pollTitleMaxLen = 50
 	pollMaxOptions  = 4
+	// Includes closed polls, but not archived ones.
+	groupMaxPolls = 32
+	// The oldest archived polls are forgotten beyond this.
+	groupMaxArchivedPolls = 32
+	// The ID of the poll from before groups could have several.
+	legacyPollID PollID = 1
 )
 
+var errTooManyPolls = errors.New("too many open polls")
+
 // New poll sent over JSON.
-type PutPollRequest struct {
+type PatchPollsRequest struct {
 	Title   string   `json:"title"`
 	Options []string `json:"options"`
 }
 
-// New votes sent over JSON.
+// Poll ID sent over JSON.
+type PatchPollsResponse struct {
+	PollID PollID `json:"pollId"`
+}
+
+// New votes and/or closed state sent over JSON.
 type PatchPollRequest struct {
-	Votes []string `json:"votes"`
+	Votes  []string `json:"votes"`
+	Closed *bool    `json:"closed"`
 }
 
 // API's related to polls within a group.
 func RestGroupPollAPI(router *mux.Router, database Database, notification Notification) {
-	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	router.HandleFunc("/{pollID}/", func(w http.ResponseWriter, r *http.Request) {
+		pollID, ok := ParseUint64PathParameter(w, r, "pollID")
+		if !ok {
+			return
+		}
+
 		user := r.Context().Value(UserKey).(*User)
 		group := r.Context().Value(GroupKey).(*Group)
 
@@ -37,66 +57,69 @@ func RestGroupPollAPI(router *mux.Router, database Database, notification Notifi
 			return
 		}
 
+		group.migratePoll()
+		index := slices.IndexFunc(group.Polls, func(poll Poll) bool { return poll.PollID == pollID })
+		if index < 0 {
+			http.Error(w, "poll not found", http.StatusNotFound)
+			return
+		}
+		poll := group.Polls[index]
+
 		switch r.Method {
-		case http.MethodPut:
-			var request PutPollRequest
+		case http.MethodPatch:
+			var request PatchPollRequest
 			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 				http.Error(w, "could not decode body", http.StatusBadRequest)
 				return
 			}
 
-			if invalidString(w, request.Title, pollTitleMinLen, pollTitleMaxLen) {
+			if poll.Archived {
+				http.Error(w, "poll is archived", http.StatusBadRequest)
 				return
 			}
-
-			var options = []PollOption{}
-
-			for _, name := range request.Options {
-				if invalidAppend(w, options, pollMaxOptions) {
-					return
-				}
-				options = append(options, PollOption{Name: name, Votes: []UserID{}})
-			}
-
-			if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
-				group.Poll = &Poll{
-					Title:     request.Title,
-					Timestamp: unixMillis(),
-					Options:   options,
-					DoneFlag:  false,
-				}
-				return nil
-			}, database, notification); err != nil {
-				http.Error(w, "could not create poll", http.StatusInternalServerError)
+			if request.Closed != nil && *request.Closed != poll.Closed && poll.Creator != user.UserID && !group.IsAdmin(user.UserID) {
+				http.Error(w, "must be poll creator or group admin", http.StatusUnauthorized)
 				return
 			}
-
-			WriteJSON(w, nil)
-		case http.MethodPatch:
-			var request PatchPollRequest
-			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-				http.Error(w, "could not decode body", http.StatusBadRequest)
-				return
+			closed := poll.Closed
+			if request.Closed != nil {
+				closed = *request.Closed
 			}
-
-			if group.Poll == nil {
-				http.Error(w, "no such poll", http.StatusNotFound)
+			if request.Votes != nil && closed {
+				http.Error(w, "poll is closed", http.StatusBadRequest)
 				return
 			}
 
 			if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
-				if group.Poll == nil {
+				group.migratePoll()
+				group.Polls = slices.Clone(group.Polls)
+				index := slices.IndexFunc(group.Polls, func(poll Poll) bool { return poll.PollID == pollID })
+				if index < 0 {
 					return fmt.Errorf("no such poll")
 				}
-				for i := range group.Poll.Options {
-					option := &group.Poll.Options[i]
-					option.Votes = slices.DeleteFunc(option.Votes, func(o UserID) bool {
+				poll := &group.Polls[index]
+				if poll.Archived {
+					return fmt.Errorf("poll is archived")
+				}
+				if request.Closed != nil {
+					poll.Closed = *request.Closed
+				}
+				if request.Votes == nil {
+					return nil
+				}
+				if poll.Closed {
+					return fmt.Errorf("poll is closed")
+				}
+				poll.Options = slices.Clone(poll.Options)
+				for i := range poll.Options {
+					option := &poll.Options[i]
+					option.Votes = slices.DeleteFunc(slices.Clone(option.Votes), func(o UserID) bool {
 						return o == user.UserID
 					})
 				}
 				for _, vote := range request.Votes {
-					for i := range group.Poll.Options {
-						opt := &group.Poll.Options[i]
+					for i := range poll.Options {
+						opt := &poll.Options[i]
 						if opt.Name == vote {
 							opt.Votes = append(opt.Votes, user.UserID)
 							break
@@ -105,20 +128,172 @@ func RestGroupPollAPI(router *mux.Router, database Database, notification Notifi
 				}
 				return nil
 			}, database, notification); err != nil {
-				http.Error(w, "could not vote in poll", http.StatusInternalServerError)
+				http.Error(w, "could not update poll", http.StatusInternalServerError)
 				return
 			}
 
 			WriteJSON(w, nil)
 		case http.MethodDelete:
+			if poll.Creator != user.UserID && !group.IsAdmin(user.UserID) {
+				http.Error(w, "must be poll creator or group admin", http.StatusUnauthorized)
+				return
+			}
 			if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
-				group.Poll = nil
+				group.migratePoll()
+				group.Polls = slices.Clone(group.Polls)
+				index := slices.IndexFunc(group.Polls, func(poll Poll) bool { return poll.PollID == pollID })
+				if index >= 0 {
+					group.archivePoll(index)
+				}
 				return nil
 			}, database, notification); err != nil {
 				http.Error(w, "could not delete poll", http.StatusInternalServerError)
 				return
 			}
 			WriteJSON(w, nil)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var request PatchPollsRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "could not decode body", http.StatusBadRequest)
+			return
+		}
+
+		user := r.Context().Value(UserKey).(*User)
+		group := r.Context().Value(GroupKey).(*Group)
+
+		if !group.IsMember(user.UserID) {
+			http.Error(w, "not a member of group", http.StatusUnauthorized)
+			return
+		}
+
+		poll, ok := createPoll(w, group, user, request, database, notification)
+		if !ok {
+			return
+		}
+		postSystemMessage(group, MessageKindPollCreated, user.UserID, poll.Title, database, notification)
+
+		WriteJSON(w, PatchPollsResponse{
+			PollID: poll.PollID,
+		})
+	})
+}
+
+// Helper to create a poll, shared by the poll API and chat commands. The user
+// must be a member.
+//
+// If returns false, error has been sent and should return.
+func createPoll(w http.ResponseWriter, group *Group, user *User, request PatchPollsRequest, database Database, notification Notification) (Poll, bool) {
+	if invalidString(w, request.Title, pollTitleMinLen, pollTitleMaxLen) {
+		return Poll{}, false
+	}
+
+	room := *group
+	room.migratePoll()
+	if !room.makeRoomForPoll() {
+		http.Error(w, errTooManyPolls.Error(), http.StatusBadRequest)
+		return Poll{}, false
+	}
+
+	var options = []PollOption{}
+
+	for _, name := range request.Options {
+		if invalidAppend(w, options, pollMaxOptions) {
+			return Poll{}, false
+		}
+		options = append(options, PollOption{Name: name, Votes: []UserID{}})
+	}
+
+	poll := Poll{
+		PollID:    GenerateID(),
+		Creator:   user.UserID,
+		Title:     request.Title,
+		Timestamp: unixMillis(),
+		Options:   options,
+	}
+	if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+		group.migratePoll()
+		if !group.makeRoomForPoll() {
+			return errTooManyPolls
+		}
+		group.Polls = append(slices.Clip(group.Polls), poll)
+		return nil
+	}, database, notification); err != nil {
+		if errors.Is(err, errTooManyPolls) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return Poll{}, false
+		}
+		http.Error(w, "could not create poll", http.StatusInternalServerError)
+		return Poll{}, false
+	}
+	return poll, true
+}
+
+// Moves the group's poll from before groups could have several into `Polls`,
+// returning whether there was one. It always gets the same ID, so it can be
+// shown before it is migrated.
+func (group *Group) migratePoll() bool {
+	if group.Poll == nil {
+		return false
+	}
+	poll := *group.Poll
+	poll.PollID = legacyPollID
+	group.Polls = append(slices.Clip(group.Polls), poll)
+	group.Poll = nil
+	return true
+}
+
+// Archives the oldest closed polls, if needed for another to fit under
+// `groupMaxPolls`. Returns false if there are too many open polls.
+func (group *Group) makeRoomForPoll() bool {
+	group.Polls = slices.Clone(group.Polls)
+	for {
+		count := 0
+		oldestClosed := -1
+		for i, poll := range group.Polls {
+			if poll.Archived {
+				continue
+			}
+			count++
+			if poll.Closed && oldestClosed < 0 {
+				oldestClosed = i
+			}
+		}
+		if count < groupMaxPolls {
+			return true
+		}
+		if oldestClosed < 0 {
+			return false
+		}
+		group.archivePoll(oldestClosed)
+	}
+}
+
+// Closes and archives a poll, keeping its results but hiding it from the list
+// of polls, and forgets the oldest archived polls beyond
+// `groupMaxArchivedPolls`. `group.Polls` must not be shared.
+func (group *Group) archivePoll(index int) {
+	group.Polls[index].Closed = true
+	group.Polls[index].Archived = true
+	archived := 0
+	for _, poll := range group.Polls {
+		if poll.Archived {
+			archived++
+		}
+	}
+	group.Polls = slices.DeleteFunc(group.Polls, func(poll Poll) bool {
+		if poll.Archived && archived > groupMaxArchivedPolls {
+			archived--
+			return true
 		}
+		return false
 	})
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"slices"
 
@@ -20,10 +21,12 @@ type PatchTaskRequest struct {
 	Title     string  `json:"title"`
 	Assignee  *UserID `json:"assignee"`
 	Completed *bool   `json:"completed"`
+	// Date like "2006-01-02", or empty string to remove due date.
+	Due *string `json:"due"`
 }
 
 // API's related to activities within a group.
-func RestGroupTaskAPI(router *mux.Router, database Database, notification Notification) {
+func RestGroupTaskAPI(router *mux.Router, database Database, notification Notification, scheduler Scheduler) {
 	router.HandleFunc("/{taskID}/", func(w http.ResponseWriter, r *http.Request) {
 		taskID, ok := ParseUint64PathParameter(w, r, "taskID")
 		if !ok {
@@ -56,11 +59,18 @@ func RestGroupTaskAPI(router *mux.Router, database Database, notification Notifi
 			if invalidString(w, request.Title, 0, taskTitleMaxLen) {
 				return
 			}
+			if request.Due != nil && *request.Due != "" && invalidDate(w, *request.Due) {
+				return
+			}
 			if request.Assignee != nil && !group.IsMember(*request.Assignee) {
 				http.Error(w, "assignee not in group", http.StatusBadRequest)
 			}
 
+			var rescheduled *Task
+			var completed *Task
 			if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+				rescheduled = nil
+				completed = nil
 				for i := range group.Tasks {
 					task := &group.Tasks[i]
 					if task.TaskID != taskID {
@@ -75,8 +85,19 @@ func RestGroupTaskAPI(router *mux.Router, database Database, notification Notifi
 						}
 						task.Assignee = *request.Assignee
 					}
-					if request.Completed != nil {
+					if request.Completed != nil && *request.Completed != task.Completed {
 						task.Completed = *request.Completed
+						// No reminder is needed once the task is complete.
+						rescheduled = task
+						if task.Completed {
+							completed = task
+						}
+					}
+					if request.Due != nil && *request.Due != task.Due {
+						task.Due = *request.Due
+						// The assignee should be reminded of the new due date.
+						task.Reminded = false
+						rescheduled = task
 					}
 				}
 				return nil
@@ -84,8 +105,34 @@ func RestGroupTaskAPI(router *mux.Router, database Database, notification Notifi
 				http.Error(w, "could not update task", http.StatusInternalServerError)
 				return
 			}
+
+			if completed != nil {
+				postSystemMessage(group, MessageKindTaskCompleted, user.UserID, completed.Title, database, notification)
+			}
+
+			if rescheduled != nil {
+				if jobID := scheduleTaskReminder(group.GroupID, *rescheduled, scheduler); jobID != rescheduled.ReminderJob {
+					if err := database.UpdateGroup(group.GroupID, func(group *Group) error {
+						for i := range group.Tasks {
+							if group.Tasks[i].TaskID == taskID {
+								group.Tasks[i].ReminderJob = jobID
+							}
+						}
+						return nil
+					}); err != nil {
+						log.Printf("could not save reminder job of task %d: %v\n", taskID, err)
+					}
+				}
+			}
+
 			WriteJSON(w, nil)
 		case http.MethodDelete:
+			if !group.IsAdmin(user.UserID) && !slices.ContainsFunc(group.Tasks, func(task Task) bool {
+				return task.TaskID == taskID && task.Assignee == user.UserID
+			}) {
+				http.Error(w, "must be task assignee or group admin", http.StatusUnauthorized)
+				return
+			}
 			if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
 				group.Tasks = slices.DeleteFunc(group.Tasks, func(task Task) bool {
 					return task.TaskID == taskID
@@ -95,6 +142,11 @@ func RestGroupTaskAPI(router *mux.Router, database Database, notification Notifi
 				http.Error(w, "could not delete task", http.StatusInternalServerError)
 				return
 			}
+			for _, task := range group.Tasks {
+				if task.TaskID == taskID {
+					cancelReminder(task.ReminderJob, scheduler)
+				}
+			}
 			WriteJSON(w, nil)
 		default:
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -112,10 +164,6 @@ func RestGroupTaskAPI(router *mux.Router, database Database, notification Notifi
 			return
 		}
 
-		if invalidString(w, request.Title, taskTitleMinLen, taskTitleMaxLen) {
-			return
-		}
-
 		user := r.Context().Value(UserKey).(*User)
 		group := r.Context().Value(GroupKey).(*Group)
 
@@ -124,27 +172,52 @@ func RestGroupTaskAPI(router *mux.Router, database Database, notification Notifi
 			return
 		}
 
-		if invalidAppend(w, group.Tasks, groupMaxTasks) {
-			return
-		}
-
-		completed := false
-		assignee := user.UserID
-		if request.Completed != nil {
-			completed = *request.Completed
-		}
-		if request.Assignee != nil && group.IsMember(*request.Assignee) {
-			assignee = *request.Assignee
-		}
-
-		if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
-			group.Tasks = append(group.Tasks, Task{TaskID: GenerateID(), Title: request.Title, Completed: completed, Assignee: assignee})
-			return nil
-		}, database, notification); err != nil {
-			http.Error(w, "could not create task", http.StatusInternalServerError)
+		if _, ok := createTask(w, group, user, request, database, notification, scheduler); !ok {
 			return
 		}
 
 		WriteJSON(w, nil)
 	})
 }
+
+// Helper to create a task, shared by the task API and chat commands. The user
+// must be a member.
+//
+// If returns false, error has been sent and should return.
+func createTask(w http.ResponseWriter, group *Group, user *User, request PatchTaskRequest, database Database, notification Notification, scheduler Scheduler) (Task, bool) {
+	if invalidString(w, request.Title, taskTitleMinLen, taskTitleMaxLen) {
+		return Task{}, false
+	}
+	if request.Due != nil && *request.Due != "" && invalidDate(w, *request.Due) {
+		return Task{}, false
+	}
+
+	if invalidAppend(w, group.Tasks, groupMaxTasks) {
+		return Task{}, false
+	}
+
+	completed := false
+	assignee := user.UserID
+	if request.Completed != nil {
+		completed = *request.Completed
+	}
+	if request.Assignee != nil && group.IsMember(*request.Assignee) {
+		assignee = *request.Assignee
+	}
+
+	task := Task{TaskID: GenerateID(), Title: request.Title, Completed: completed, Assignee: assignee}
+	if request.Due != nil {
+		task.Due = *request.Due
+	}
+	task.ReminderJob = scheduleTaskReminder(group.GroupID, task, scheduler)
+
+	if err := updateAndNotifyGroup(group.GroupID, func(group *Group) error {
+		group.Tasks = append(group.Tasks, task)
+		return nil
+	}, database, notification); err != nil {
+		cancelReminder(task.ReminderJob, scheduler)
+		http.Error(w, "could not create task", http.StatusInternalServerError)
+		return Task{}, false
+	}
+	return task, true
+}
@@ -10,6 +10,7 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
 	"time"
 
@@ -73,7 +74,7 @@ func newLambdaHandler(database Database, notification Notification, scheduler Sc
 					}
 				}
 				//log.Printf("ws req: %v", request)
-				user, err := CheckCookie(request, database)
+				user, err := CheckCookie(nil, request, database)
 				if err != nil {
 					return events.APIGatewayProxyResponse{}, err
 				}
@@ -88,6 +89,22 @@ func newLambdaHandler(database Database, notification Notification, scheduler Sc
 
 			if isConnect || isDisconnect {
 				err = WebSocket(database, ws.RequestContext.ConnectionID, connectUserID)
+			} else if ws.RequestContext.EventType == "MESSAGE" {
+				err = WebSocketMessage(database, notification, ws.RequestContext.ConnectionID, []byte(ws.Body))
+				if isWebSocketClientError(err) {
+					// The client sent a bad message, which isn't worth retrying.
+					return events.APIGatewayProxyResponse{
+						StatusCode: http.StatusBadRequest,
+						Body:       err.Error(),
+					}, nil
+				}
+				if err != nil {
+					log.Printf("websocket %s message: %v\n", ws.RequestContext.ConnectionID, err)
+					return events.APIGatewayProxyResponse{
+						StatusCode: http.StatusInternalServerError,
+						Body:       "could not handle message",
+					}, nil
+				}
 			}
 			return events.APIGatewayProxyResponse{
 				StatusCode: http.StatusOK,
@@ -98,12 +115,25 @@ func newLambdaHandler(database Database, notification Notification, scheduler Sc
 		// Check if the event is an AWS EventBridge cron event.
 		var cron events.EventBridgeEvent
 		if err := json.Unmarshal(event, &cron); err == nil && cron.DetailType != "" {
+			if job := findCronJob(cron.DetailType); job != nil {
+				log.Printf("received EventBridge event for cron job %s\n", job.Name)
+				err := job.Run(time.Now(), database, notification)
+				return events.APIGatewayProxyResponse{}, err
+			}
 			log.Println("received EventBridge event")
 			var activation Activation
 			if err := json.Unmarshal(cron.Detail, &activation); err != nil {
 				return events.APIGatewayProxyResponse{}, err
 			}
-			err := Activate(activation)
+			err := Activate(activation, database, notification)
+			return events.APIGatewayProxyResponse{}, err
+		}
+
+		// Check if the event is an activation from the EventBridge scheduler.
+		var activation Activation
+		if err := json.Unmarshal(event, &activation); err == nil && (activation.UserID != nil || activation.GroupID != nil) {
+			log.Println("received scheduled activation")
+			err := Activate(activation, database, notification)
 			return events.APIGatewayProxyResponse{}, err
 		}
 
@@ -121,26 +151,40 @@ func runLambdaService() {
 
 	database := NewDynamoDB(sess)
 	notification := NewApiGateway(sess)
-	scheduler := NewEventBridgeScheduler(sess)
+	scheduler := NewEventBridgeScheduler(sess, database)
 
 	// Start handling events forever.
 	lambda.Start(newLambdaHandler(database, notification, scheduler))
 }
 
-// Handle events forever on localhost with a volatile database.
+// Handle events forever on localhost with a volatile database, or a
+// non-volatile one if the DATABASE_PATH environment variable names a file.
+// Scheduled and cron jobs run according to `clock`.
 //
 // Returns errors except if they were due to ctx being canceled.
-func runLocalService(port uint16, ctx context.Context) error {
-	database := NewMemoryDatabase()
+func runLocalService(port uint16, clock Clock, ctx context.Context) error {
+	var database Database = NewMemoryDatabase()
+	if path := os.Getenv("DATABASE_PATH"); path != "" {
+		boltDatabase, err := NewBoltDatabase(path)
+		if err != nil {
+			return err
+		}
+		defer boltDatabase.Close()
+		log.Printf("using database file %s\n", path)
+		database = boltDatabase
+	}
 	notification := NewLocalNotification()
-	scheduler := NewLocalScheduler()
+	scheduler, err := NewLocalScheduler(database, notification, clock)
+	if err != nil {
+		return err
+	}
 
 	router := mux.NewRouter()
 	upgrader := websocket.Upgrader{} // use default options
 
 	// In addition to the Rest API, expose WebSocket capabilities.
 	router.HandleFunc("/ws/", func(w http.ResponseWriter, r *http.Request) {
-		user, err := CheckCookie(r, database)
+		user, err := CheckCookie(nil, r, database)
 		if err != nil {
 			http.Error(w, "could not check cookie", http.StatusInternalServerError)
 			return
@@ -165,10 +209,16 @@ func runLocalService(port uint16, ctx context.Context) error {
 			defer notification.remove(connectionID)
 			defer WebSocket(database, connectionID, nil)
 			for {
-				messageType, _, err := c.ReadMessage()
+				messageType, message, err := c.ReadMessage()
 				if err != nil || messageType == websocket.CloseMessage {
 					break
 				}
+				if messageType != websocket.TextMessage {
+					continue
+				}
+				if err := WebSocketMessage(database, notification, connectionID, message); err != nil {
+					log.Printf("websocket %s message: %v\n", connectionID, err)
+				}
 			}
 		}()
 	})
@@ -213,20 +263,19 @@ func runLocalService(port uint16, ctx context.Context) error {
 		BaseContext:    func(net.Listener) context.Context { return ctx },
 	}
 
-	// Run cron job every hour. If ctx cancelled, shut down the server.
+	// Run cron jobs until ctx cancelled.
 	go func() {
-		now := time.Now()
-		sleep := 60 - now.Minute()
-		select {
-		case <-ctx.Done():
-			// ctx cancelled
-			_ = s.Close()
-			return
-		case <-time.After(time.Duration(int64(sleep) * int64(time.Minute))):
-			Activate(Activation{})
+		if err := runCronJobs(ctx, cronJobs, clock, database, notification); err != nil {
+			log.Printf("could not run cron jobs: %v\n", err)
 		}
 	}()
 
+	// If ctx cancelled, shut down the server.
+	go func() {
+		<-ctx.Done()
+		_ = s.Close()
+	}()
+
 	// Serve HTTP until it encounters an error or the context is canceled.
 	err = s.ListenAndServe()
 	if errors.Is(err, http.ErrServerClosed) {
@@ -240,12 +289,27 @@ func applyCors(handler http.Handler) http.Handler {
 	return handlers.CORS(handlers.AllowCredentials(), handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}))(handler)
 }
 
+// Copy chat messages out of the legacy DynamoDB table, using credentials from
+// the environment (see `DynamoDB.MigrateLegacyMessages`).
+func runMessageMigration() {
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+	copied, err := NewDynamoDB(sess).MigrateLegacyMessages()
+	log.Printf("copied %d messages\n", copied)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
 func main() {
-	if isOnLambda() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate-messages" {
+		runMessageMigration()
+	} else if isOnLambda() {
 		runLambdaService()
 	} else {
 		const port = 8080
 		log.Printf("starting localhost service at http://localhost:%d\n", port)
-		runLocalService(port, context.Background())
+		runLocalService(port, SystemClock{}, context.Background())
 	}
 }
@@ -8,11 +8,14 @@ import (
 	"io"
 	"log"
 	"math"
-	"math/rand"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -24,12 +27,7 @@ import (
 
 // Integration test of HTTP service.
 func TestHTTPService(t *testing.T) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	port := uint16(30000 + rand.Intn(30000))
-	go runLocalService(port, ctx)
-	time.Sleep(time.Second / 10)
+	port := StartTestService(t)
 
 	jar, err := cookiejar.New(nil)
 	assert.Nil(t, err)
@@ -153,19 +151,21 @@ func TestHTTPService(t *testing.T) {
 	assert.Equal(t, http.StatusOK, response.StatusCode)
 
 	// Test: create poll.
-	putPollRequest := PutPollRequest{
+	patchPollsRequest := PatchPollsRequest{
 		Title:   "who?",
 		Options: []string{"me", "you"},
 	}
-	response, err = Put(c, fmt.Sprintf("http://localhost:%d/api/group/%d/poll/", port, groupID), putPollRequest)
+	response, err = Patch(c, fmt.Sprintf("http://localhost:%d/api/group/%d/poll/", port, groupID), patchPollsRequest)
 	assert.Nil(t, err)
 	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var patchPollsResponse PatchPollsResponse
+	MustDecode(t, response.Body, &patchPollsResponse)
 
 	// Test: vote poll.
 	patchPollRequest := PatchPollRequest{
 		Votes: []string{"me"},
 	}
-	response, err = Patch(c, fmt.Sprintf("http://localhost:%d/api/group/%d/poll/", port, groupID), patchPollRequest)
+	response, err = Patch(c, fmt.Sprintf("http://localhost:%d/api/group/%d/poll/%d/", port, groupID, patchPollsResponse.PollID), patchPollRequest)
 	assert.Nil(t, err)
 	assert.Equal(t, http.StatusOK, response.StatusCode)
 
@@ -178,12 +178,12 @@ func TestHTTPService(t *testing.T) {
 	assert.Equal(t, http.StatusOK, response.StatusCode)
 
 	// Test: read chat.
-	response, err = c.Get(fmt.Sprintf("http://localhost:%d/api/group/%d/chat/?start=0&end=%s", port, groupID, strconv.FormatUint(math.MaxUint64, 10)))
+	response, err = c.Get(fmt.Sprintf("http://localhost:%d/api/group/%d/chat/?start=0&end=%s&system=false", port, groupID, strconv.FormatUint(math.MaxUint64, 10)))
 	assert.Nil(t, err)
 	assert.Equal(t, http.StatusOK, response.StatusCode)
 	var getChatResponse GetChatResponse
 	MustDecode(t, response.Body, &getChatResponse)
-	assert.Equal(t, false, getChatResponse.Continue)
+	assert.Empty(t, getChatResponse.Continue)
 	assert.Equal(t, 1, len(getChatResponse.Messages))
 	assert.Equal(t, userID, getChatResponse.Messages[0].Sender)
 	assert.Equal(t, "hi s***", getChatResponse.Messages[0].Content)
@@ -226,11 +226,12 @@ func TestHTTPService(t *testing.T) {
 	assert.Equal(t, patchGroupRequest.Name, getGroupResponse.Name)
 	assert.Equal(t, patchGroupRequest.CalendarMode, getGroupResponse.CalendarMode)
 	assert.Equal(t, []UserID{userID}, getGroupResponse.Members)
-	assert.NotNil(t, getGroupResponse.Poll)
-	assert.Equal(t, putPollRequest.Title, getGroupResponse.Poll.Title)
-	assert.Equal(t, len(putPollRequest.Options), len(getGroupResponse.Poll.Options))
-	for i, option := range putPollRequest.Options {
-		assert.Equal(t, option, getGroupResponse.Poll.Options[i].Name)
+	assert.Len(t, getGroupResponse.Polls, 1)
+	assert.Equal(t, patchPollsResponse.PollID, getGroupResponse.Polls[0].PollID)
+	assert.Equal(t, patchPollsRequest.Title, getGroupResponse.Polls[0].Title)
+	assert.Equal(t, len(patchPollsRequest.Options), len(getGroupResponse.Polls[0].Options))
+	for i, option := range patchPollsRequest.Options {
+		assert.Equal(t, option, getGroupResponse.Polls[0].Options[i].Name)
 	}
 	assert.Equal(t, 1, len(getGroupResponse.Activities))
 	assert.Equal(t, patchActivityRequest.Title, getGroupResponse.Activities[0].Title)
@@ -280,7 +281,7 @@ func TestHTTPService(t *testing.T) {
 	assert.Equal(t, http.StatusOK, response.StatusCode)
 
 	// Test: delete poll.
-	response, err = Delete(c, fmt.Sprintf("http://localhost:%d/api/group/%d/poll/", port, groupID))
+	response, err = Delete(c, fmt.Sprintf("http://localhost:%d/api/group/%d/poll/%d/", port, groupID, patchPollsResponse.PollID))
 	assert.Nil(t, err)
 	assert.Equal(t, http.StatusOK, response.StatusCode)
 
@@ -293,13 +294,31 @@ func TestHTTPService(t *testing.T) {
 	assert.Empty(t, getGroupResponse2.Activities)
 	assert.Empty(t, getGroupResponse2.Availabilities)
 	assert.Empty(t, getGroupResponse2.Tasks)
-	assert.Nil(t, getGroupResponse2.Poll)
+	assert.Empty(t, getGroupResponse2.Polls)
 
 	// Test: leave/delete group.
 	response, err = Delete(c, fmt.Sprintf("http://localhost:%d/api/group/%d/", port, groupID))
 	assert.Nil(t, err)
 	assert.Equal(t, http.StatusOK, response.StatusCode)
 
+	// Test: forged session is rejected.
+	forgedJar, err := cookiejar.New(nil)
+	assert.Nil(t, err)
+	forgedJar.SetCookies(&url.URL{Scheme: "http", Host: fmt.Sprintf("localhost:%d", port)}, []*http.Cookie{{Name: "userID", Value: strconv.FormatUint(userID, 10)}, {Name: "session", Value: "deadbeef.forged"}})
+	response, err = (&http.Client{Jar: forgedJar}).Get(fmt.Sprintf("http://localhost:%d/api/push/", port))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	// Test: log out.
+	response, err = Delete(c, fmt.Sprintf("http://localhost:%d/api/session/", port))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	// Test: revoked session is rejected.
+	response, err = c.Get(fmt.Sprintf("http://localhost:%d/api/push/", port))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
 	assert.Greater(t, groupChanges.Load(), uint64(0))
 	assert.Greater(t, userChanges.Load(), uint64(0))
 	assert.Greater(t, messagesReceived.Load(), uint64(0))
@@ -307,35 +326,1815 @@ func TestHTTPService(t *testing.T) {
 	log.Printf("notifications:  group=%d user=%d message=%d\n", groupChanges.Load(), userChanges.Load(), messagesReceived.Load())
 }
 
-// Integration test of Lambda handler.
-func TestLambdaHandler(t *testing.T) {
-	type Case struct {
-		request  json.RawMessage
-		response json.RawMessage
-		err      error
+// Integration test of joining groups via invites.
+func TestGroupInvites(t *testing.T) {
+	port := StartTestService(t)
+
+	owner, _ := NewTestUser(t, port)
+	guest, _ := NewTestUser(t, port)
+	latecomer, _ := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, owner)
+
+	// Test: non-member cannot join by reading the group.
+	response, err := guest.Get(fmt.Sprintf("http://localhost:%d/api/group/%d/", port, groupID))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	// Test: non-member cannot create an invite.
+	response, err = Patch(guest, fmt.Sprintf("http://localhost:%d/api/group/%d/invite/", port, groupID), PatchInviteRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	// Test: create single-use invite.
+	response, err = Patch(owner, fmt.Sprintf("http://localhost:%d/api/group/%d/invite/", port, groupID), PatchInviteRequest{MaxUses: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var patchInviteResponse PatchInviteResponse
+	MustDecode(t, response.Body, &patchInviteResponse)
+	assert.NotEmpty(t, patchInviteResponse.Token)
+
+	// Test: list invites.
+	response, err = owner.Get(fmt.Sprintf("http://localhost:%d/api/group/%d/invite/", port, groupID))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var getInviteResponse []GetInviteResponse
+	MustDecode(t, response.Body, &getInviteResponse)
+	assert.Equal(t, 1, len(getInviteResponse))
+	assert.Equal(t, patchInviteResponse.Token, getInviteResponse[0].Token)
+
+	// Test: wrong token is rejected.
+	response, err = Patch(guest, fmt.Sprintf("http://localhost:%d/api/group/%d/join/", port, groupID), PatchJoinRequest{Token: "sus"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	// Test: redeem invite.
+	response, err = Patch(guest, fmt.Sprintf("http://localhost:%d/api/group/%d/join/", port, groupID), PatchJoinRequest{Token: patchInviteResponse.Token})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	response, err = guest.Get(fmt.Sprintf("http://localhost:%d/api/group/%d/", port, groupID))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var getGroupResponse GetGroupResponse
+	MustDecode(t, response.Body, &getGroupResponse)
+	assert.Equal(t, 2, len(getGroupResponse.Members))
+
+	// Test: used-up invite is rejected.
+	response, err = Patch(latecomer, fmt.Sprintf("http://localhost:%d/api/group/%d/join/", port, groupID), PatchJoinRequest{Token: patchInviteResponse.Token})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	// Test: only the creator or an admin can revoke an invite.
+	response, err = Patch(guest, fmt.Sprintf("http://localhost:%d/api/group/%d/invite/", port, groupID), PatchInviteRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	MustDecode(t, response.Body, &patchInviteResponse)
+	response, err = Delete(guest, fmt.Sprintf("http://localhost:%d/api/group/%d/invite/%s/", port, groupID, patchInviteResponse.Token))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response, err = Patch(owner, fmt.Sprintf("http://localhost:%d/api/group/%d/invite/", port, groupID), PatchInviteRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	MustDecode(t, response.Body, &patchInviteResponse)
+	response, err = Delete(guest, fmt.Sprintf("http://localhost:%d/api/group/%d/invite/%s/", port, groupID, patchInviteResponse.Token))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	// Test: revoke invite.
+	response, err = Delete(owner, fmt.Sprintf("http://localhost:%d/api/group/%d/invite/%s/", port, groupID, patchInviteResponse.Token))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response, err = Patch(latecomer, fmt.Sprintf("http://localhost:%d/api/group/%d/join/", port, groupID), PatchJoinRequest{Token: patchInviteResponse.Token})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	// Test: invites are limited, but used-up invites don't count.
+	for i := 0; i < groupMaxInvites; i++ {
+		response, err = Patch(owner, fmt.Sprintf("http://localhost:%d/api/group/%d/invite/", port, groupID), PatchInviteRequest{})
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
 	}
+	response, err = Patch(owner, fmt.Sprintf("http://localhost:%d/api/group/%d/invite/", port, groupID), PatchInviteRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
 
-	tests := []Case{
-		{
-			request:  MustMarshal(t, &events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/"}),
-			response: json.RawMessage("404 page not found\n"),
-			err:      nil,
-		},
+// Integration test of group roles and permissions.
+func TestGroupRoles(t *testing.T) {
+	port := StartTestService(t)
+
+	owner, ownerID := NewTestUser(t, port)
+	member, memberID := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, owner)
+	JoinTestGroup(t, port, owner, member, groupID)
+
+	// Test: plain member cannot rename group.
+	response, err := Patch(member, fmt.Sprintf("http://localhost:%d/api/group/%d/", port, groupID), PatchGroupRequest{Name: "mine"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	// Test: plain member cannot delete another member's activity.
+	response, err = Patch(owner, fmt.Sprintf("http://localhost:%d/api/group/%d/activity/", port, groupID), PatchActivityRequest{Title: "hang out", Date: "2024-02-15", Start: "18:00", End: "19:00"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	getGroupResponse := GetTestGroup(t, port, member, groupID)
+	assert.Equal(t, ownerID, getGroupResponse.Owner)
+	assert.Equal(t, 1, len(getGroupResponse.Activities))
+	assert.Equal(t, ownerID, getGroupResponse.Activities[0].Creator)
+	activityID := getGroupResponse.Activities[0].ActivityID
+	response, err = Delete(member, fmt.Sprintf("http://localhost:%d/api/group/%d/activity/%d/", port, groupID, activityID))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	// Test: plain member cannot change roles.
+	response, err = Patch(member, fmt.Sprintf("http://localhost:%d/api/group/%d/member/%d/", port, groupID, memberID), PatchMemberRequest{Role: RoleAdmin})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	// Test: promote to admin.
+	response, err = Patch(owner, fmt.Sprintf("http://localhost:%d/api/group/%d/member/%d/", port, groupID, memberID), PatchMemberRequest{Role: RoleAdmin})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	getGroupResponse = GetTestGroup(t, port, member, groupID)
+	assert.Equal(t, []UserID{memberID}, getGroupResponse.Admins)
+
+	// Test: admin can rename group and delete activity.
+	response, err = Patch(member, fmt.Sprintf("http://localhost:%d/api/group/%d/", port, groupID), PatchGroupRequest{Name: "ours"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response, err = Delete(member, fmt.Sprintf("http://localhost:%d/api/group/%d/activity/%d/", port, groupID, activityID))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	// Test: demote to member.
+	response, err = Patch(owner, fmt.Sprintf("http://localhost:%d/api/group/%d/member/%d/", port, groupID, memberID), PatchMemberRequest{Role: RoleMember})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	getGroupResponse = GetTestGroup(t, port, member, groupID)
+	assert.Empty(t, getGroupResponse.Admins)
+
+	// Test: transfer ownership.
+	response, err = Patch(owner, fmt.Sprintf("http://localhost:%d/api/group/%d/member/%d/", port, groupID, memberID), PatchMemberRequest{Role: RoleOwner})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	getGroupResponse = GetTestGroup(t, port, member, groupID)
+	assert.Equal(t, memberID, getGroupResponse.Owner)
+	assert.Equal(t, []UserID{ownerID}, getGroupResponse.Admins)
+
+	// Test: ownership passes on when the owner leaves.
+	response, err = Delete(member, fmt.Sprintf("http://localhost:%d/api/group/%d/", port, groupID))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	getGroupResponse = GetTestGroup(t, port, owner, groupID)
+	assert.Equal(t, ownerID, getGroupResponse.Owner)
+	assert.Empty(t, getGroupResponse.Admins)
+}
+
+// Integration test of removing and banning members.
+func TestGroupBans(t *testing.T) {
+	port := StartTestService(t)
+
+	owner, _ := NewTestUser(t, port)
+	member, memberID := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, owner)
+	JoinTestGroup(t, port, owner, member, groupID)
+
+	// Test: plain member cannot remove the owner.
+	getGroupResponse := GetTestGroup(t, port, member, groupID)
+	response, err := Delete(member, fmt.Sprintf("http://localhost:%d/api/group/%d/member/%d/", port, groupID, getGroupResponse.Owner))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	// Test: remove and ban member.
+	response, err = Delete(owner, fmt.Sprintf("http://localhost:%d/api/group/%d/member/%d/?ban=true", port, groupID, memberID))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	getGroupResponse = GetTestGroup(t, port, owner, groupID)
+	assert.Equal(t, 1, len(getGroupResponse.Members))
+
+	response, err = member.Get(fmt.Sprintf("http://localhost:%d/api/user/", port))
+	assert.Nil(t, err)
+	var getUserResponse GetUserResponse
+	MustDecode(t, response.Body, &getUserResponse)
+	assert.Empty(t, getUserResponse.Groups)
+
+	// Test: list bans.
+	response, err = owner.Get(fmt.Sprintf("http://localhost:%d/api/group/%d/ban/", port, groupID))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var banned []UserID
+	MustDecode(t, response.Body, &banned)
+	assert.Equal(t, []UserID{memberID}, banned)
+
+	// Test: banned user cannot rejoin.
+	response, err = Patch(owner, fmt.Sprintf("http://localhost:%d/api/group/%d/invite/", port, groupID), PatchInviteRequest{})
+	assert.Nil(t, err)
+	var patchInviteResponse PatchInviteResponse
+	MustDecode(t, response.Body, &patchInviteResponse)
+	response, err = Patch(member, fmt.Sprintf("http://localhost:%d/api/group/%d/join/", port, groupID), PatchJoinRequest{Token: patchInviteResponse.Token})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusForbidden, response.StatusCode)
+
+	// Test: un-ban and rejoin.
+	response, err = Delete(owner, fmt.Sprintf("http://localhost:%d/api/group/%d/ban/%d/", port, groupID, memberID))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response, err = Patch(member, fmt.Sprintf("http://localhost:%d/api/group/%d/join/", port, groupID), PatchJoinRequest{Token: patchInviteResponse.Token})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+}
+
+// Integration test of deleting groups.
+func TestGroupDeletion(t *testing.T) {
+	port := StartTestService(t)
+
+	owner, _ := NewTestUser(t, port)
+	member, _ := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, owner)
+	JoinTestGroup(t, port, owner, member, groupID)
+
+	// Test: plain member cannot delete group.
+	response, err := Delete(member, fmt.Sprintf("http://localhost:%d/api/group/%d/?delete=true", port, groupID))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	// Test: owner deletes group.
+	response, err = Delete(owner, fmt.Sprintf("http://localhost:%d/api/group/%d/?delete=true", port, groupID))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	response, err = member.Get(fmt.Sprintf("http://localhost:%d/api/group/%d/", port, groupID))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+	response, err = member.Get(fmt.Sprintf("http://localhost:%d/api/user/", port))
+	assert.Nil(t, err)
+	var getUserResponse GetUserResponse
+	MustDecode(t, response.Body, &getUserResponse)
+	assert.Empty(t, getUserResponse.Groups)
+}
+
+// Integration test of paging through chat.
+func TestChatPagination(t *testing.T) {
+	port := StartTestService(t)
+
+	c, _ := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, c)
+	chatURL := fmt.Sprintf("http://localhost:%d/api/group/%d/chat/", port, groupID)
+
+	const count = 7
+	for i := 0; i < count; i++ {
+		response, err := Patch(c, chatURL, PatchChatRequest{Content: strconv.Itoa(i)})
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		var patchChatResponse PatchChatResponse
+		MustDecode(t, response.Body, &patchChatResponse)
+		assert.NotZero(t, patchChatResponse.MessageID)
 	}
 
-	for _, test := range tests {
-		database := NewMemoryDatabase()
-		notification := NewLocalNotification()
-		scheduler := NewLocalScheduler()
-		context := context.Background()
-		json, err := json.Marshal(test.request)
-		if err != nil {
-			panic(err)
+	// Reads every page, following cursors, and returns the contents in the
+	// order they were received.
+	readAll := func(query string) []string {
+		var contents []string
+		for pages := 0; pages < count; pages++ {
+			response, err := c.Get(chatURL + "?" + query)
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, response.StatusCode)
+			var getChatResponse GetChatResponse
+			MustDecode(t, response.Body, &getChatResponse)
+			var page []string
+			for _, message := range getChatResponse.Messages {
+				page = append(page, message.Content)
+			}
+			contents = append(contents, strings.Join(page, ","))
+			if getChatResponse.Continue == "" {
+				break
+			}
+			query = "limit=3&cursor=" + url.QueryEscape(getChatResponse.Continue)
 		}
-		response, err := newLambdaHandler(database, notification, scheduler)(context, json)
-		assert.IsType(t, test.err, err)
-		assert.Equal(t, string(test.response), response.Body)
+		return contents
+	}
+
+	// Test: pages go backward from the latest messages by default.
+	assert.Equal(t, []string{"4,5,6", "1,2,3", "0"}, readAll("limit=3"))
+	assert.Equal(t, []string{"0,1,2", "3,4,5", "6"}, readAll("limit=3&direction=forward"))
+	assert.Equal(t, []string{"0,1,2,3,4,5,6"}, readAll(""))
+
+	// Test: messages sent at the same time are all accepted, with unique IDs.
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := Patch(c, chatURL, PatchChatRequest{Content: "same time"})
+			assert.Nil(t, err)
+			assert.Equal(t, http.StatusOK, response.StatusCode)
+		}()
+	}
+	wg.Wait()
+	response, err := c.Get(chatURL + "?limit=100")
+	assert.Nil(t, err)
+	var getChatResponse GetChatResponse
+	MustDecode(t, response.Body, &getChatResponse)
+	assert.Len(t, getChatResponse.Messages, 2*count)
+	messageIDs := make(map[MessageID]struct{})
+	for _, message := range getChatResponse.Messages {
+		messageIDs[message.MessageID] = struct{}{}
+	}
+	assert.Len(t, messageIDs, 2*count)
+
+	// Test: invalid pagination.
+	for _, query := range []string{"limit=0", "limit=sus", "cursor=sus"} {
+		response, err := c.Get(chatURL + "?" + query)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode, query)
+	}
+}
+
+// Integration test of editing and deleting chat messages.
+func TestChatEditing(t *testing.T) {
+	port := StartTestService(t)
+
+	owner, _ := NewTestUser(t, port)
+	sender, _ := NewTestUser(t, port)
+	member, _ := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, owner)
+	JoinTestGroup(t, port, owner, sender, groupID)
+	JoinTestGroup(t, port, owner, member, groupID)
+	chatURL := fmt.Sprintf("http://localhost:%d/api/group/%d/chat/", port, groupID)
+
+	send := func(content string) MessageID {
+		response, err := Patch(sender, chatURL, PatchChatRequest{Content: content})
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		var patchChatResponse PatchChatResponse
+		MustDecode(t, response.Body, &patchChatResponse)
+		return patchChatResponse.MessageID
+	}
+	readChat := func() []GetChatResponseMessage {
+		response, err := member.Get(chatURL + "?system=false")
+		assert.Nil(t, err)
+		var getChatResponse GetChatResponse
+		MustDecode(t, response.Body, &getChatResponse)
+		return getChatResponse.Messages
+	}
+	first := send("helo")
+	second := send("oops")
+
+	// Test: other members cannot edit or delete the message.
+	response, err := Patch(member, fmt.Sprintf("%s%d/", chatURL, first), PatchChatMessageRequest{Content: "hacked"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	response, err = Delete(member, fmt.Sprintf("%s%d/", chatURL, first))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	// Test: sender can edit the message.
+	response, err = Patch(sender, fmt.Sprintf("%s%d/", chatURL, first), PatchChatMessageRequest{Content: "hello"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response, err = Patch(sender, fmt.Sprintf("%s%d/", chatURL, first), PatchChatMessageRequest{Content: ""})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	// Test: admin can delete the message, leaving a tombstone.
+	response, err = Delete(owner, fmt.Sprintf("%s%d/", chatURL, second))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	messages := readChat()
+	assert.Len(t, messages, 2)
+	assert.Equal(t, "hello", messages[0].Content)
+	assert.NotZero(t, messages[0].EditedAt)
+	assert.False(t, messages[0].Deleted)
+	assert.Equal(t, second, messages[1].MessageID)
+	assert.Empty(t, messages[1].Content)
+	assert.True(t, messages[1].Deleted)
+
+	// Test: deleted and nonexistent messages cannot be changed.
+	response, err = Patch(sender, fmt.Sprintf("%s%d/", chatURL, second), PatchChatMessageRequest{Content: "back"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	response, err = Delete(sender, fmt.Sprintf("%s%d/", chatURL, second+1000))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
+
+// Integration test of reacting to chat messages.
+func TestChatReactions(t *testing.T) {
+	port := StartTestService(t)
+
+	owner, ownerID := NewTestUser(t, port)
+	member, memberID := NewTestUser(t, port)
+	outsider, _ := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, owner)
+	JoinTestGroup(t, port, owner, member, groupID)
+	chatURL := fmt.Sprintf("http://localhost:%d/api/group/%d/chat/", port, groupID)
+
+	response, err := Patch(owner, chatURL, PatchChatRequest{Content: "pizza?"})
+	assert.Nil(t, err)
+	var patchChatResponse PatchChatResponse
+	MustDecode(t, response.Body, &patchChatResponse)
+	reactionURL := func(emoji string) string {
+		return fmt.Sprintf("%s%d/reaction/%s/", chatURL, patchChatResponse.MessageID, url.PathEscape(emoji))
+	}
+	readReactions := func() map[string][]UserID {
+		response, err := owner.Get(chatURL + "?system=false")
+		assert.Nil(t, err)
+		var getChatResponse GetChatResponse
+		MustDecode(t, response.Body, &getChatResponse)
+		assert.Len(t, getChatResponse.Messages, 1)
+		return getChatResponse.Messages[0].Reactions
+	}
+
+	// Test: members can react, but only once per emoji.
+	for _, c := range []*http.Client{owner, member, member} {
+		response, err = Put(c, reactionURL("👍"), nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	}
+	response, err = Put(member, reactionURL("🎉"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, map[string][]UserID{"👍": {ownerID, memberID}, "🎉": {memberID}}, readReactions())
+
+	// Test: reactions can be removed.
+	response, err = Delete(member, reactionURL("🎉"))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response, err = Delete(owner, reactionURL("👍"))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, map[string][]UserID{"👍": {memberID}}, readReactions())
+
+	// Test: only allowed emoji, and only by members.
+	response, err = Put(member, reactionURL("sus"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	response, err = Put(outsider, reactionURL("👍"), nil)
+	assert.Nil(t, err)
+	assert.NotEqual(t, http.StatusOK, response.StatusCode)
+}
+
+// Integration test of threaded replies.
+func TestChatThreads(t *testing.T) {
+	port := StartTestService(t)
+
+	c, _ := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, c)
+	chatURL := fmt.Sprintf("http://localhost:%d/api/group/%d/chat/", port, groupID)
+
+	send := func(request PatchChatRequest) (*http.Response, MessageID) {
+		response, err := Patch(c, chatURL, request)
+		assert.Nil(t, err)
+		var patchChatResponse PatchChatResponse
+		if response.StatusCode == http.StatusOK {
+			MustDecode(t, response.Body, &patchChatResponse)
+		}
+		return response, patchChatResponse.MessageID
+	}
+	read := func(url string) GetChatResponse {
+		response, err := c.Get(url)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		var getChatResponse GetChatResponse
+		MustDecode(t, response.Body, &getChatResponse)
+		return getChatResponse
+	}
+
+	_, parentID := send(PatchChatRequest{Content: "where to?"})
+	const count = 5
+	var replyID MessageID
+	for i := 0; i < count; i++ {
+		var response *http.Response
+		response, replyID = send(PatchChatRequest{Content: strconv.Itoa(i), ParentID: parentID})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	}
+	send(PatchChatRequest{Content: "anyway"})
+
+	// Test: replies are not in the main chat, but are counted on the parent.
+	chat := read(chatURL)
+	assert.Len(t, chat.Messages, 2)
+	assert.Equal(t, parentID, chat.Messages[0].MessageID)
+	assert.Equal(t, uint64(count), chat.Messages[0].ReplyCount)
+	assert.NotZero(t, chat.Messages[0].LastReply)
+	assert.Zero(t, chat.Messages[1].ReplyCount)
+
+	// Test: replies are paged like the main chat.
+	thread := read(fmt.Sprintf("%s%d/thread/?limit=3", chatURL, parentID))
+	assert.Len(t, thread.Messages, 3)
+	assert.Equal(t, "2", thread.Messages[0].Content)
+	assert.Equal(t, parentID, thread.Messages[0].ParentID)
+	assert.NotEmpty(t, thread.Continue)
+	thread = read(fmt.Sprintf("%s%d/thread/?limit=3&cursor=%s", chatURL, parentID, url.QueryEscape(thread.Continue)))
+	assert.Len(t, thread.Messages, 2)
+	assert.Equal(t, "0", thread.Messages[0].Content)
+	assert.Empty(t, thread.Continue)
+	thread = read(fmt.Sprintf("%s%d/thread/?end=%d", chatURL, parentID, chat.Messages[0].Timestamp-1))
+	assert.Empty(t, thread.Messages)
+
+	// Test: threads don't nest, and must have a parent.
+	response, _ := send(PatchChatRequest{Content: "deeper", ParentID: replyID})
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	response, _ = send(PatchChatRequest{Content: "lost", ParentID: replyID + 1000})
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	response, err := c.Get(fmt.Sprintf("%s%d/thread/", chatURL, replyID+1000))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
+
+// Integration test of mentioning members in chat.
+func TestChatMentions(t *testing.T) {
+	port := StartTestService(t)
+
+	owner, _ := NewTestUser(t, port)
+	member, memberID := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, owner)
+	JoinTestGroup(t, port, owner, member, groupID)
+	pushMentionsOnly := true
+	response, err := Patch(member, fmt.Sprintf("http://localhost:%d/api/user/", port), PatchUserRequest{Name: "Robin", PushMentionsOnly: &pushMentionsOnly})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	chatURL := fmt.Sprintf("http://localhost:%d/api/group/%d/chat/", port, groupID)
+
+	// Test: the preference is saved.
+	response, err = member.Get(fmt.Sprintf("http://localhost:%d/api/user/", port))
+	assert.Nil(t, err)
+	var getUserResponse GetUserResponse
+	MustDecode(t, response.Body, &getUserResponse)
+	assert.True(t, getUserResponse.PushMentionsOnly)
+
+	// Test: mentions are stored, and updated by edits.
+	response, err = Patch(owner, chatURL, PatchChatRequest{Content: "@robin, bring snacks"})
+	assert.Nil(t, err)
+	var patchChatResponse PatchChatResponse
+	MustDecode(t, response.Body, &patchChatResponse)
+	response, err = Patch(owner, chatURL, PatchChatRequest{Content: "@robinhood"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response, err = owner.Get(chatURL + "?system=false")
+	assert.Nil(t, err)
+	var getChatResponse GetChatResponse
+	MustDecode(t, response.Body, &getChatResponse)
+	assert.Len(t, getChatResponse.Messages, 2)
+	assert.Equal(t, []UserID{memberID}, getChatResponse.Messages[0].Mentions)
+	assert.Empty(t, getChatResponse.Messages[1].Mentions)
+	response, err = Patch(owner, fmt.Sprintf("%s%d/", chatURL, patchChatResponse.MessageID), PatchChatMessageRequest{Content: "bring snacks"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response, err = owner.Get(chatURL + "?system=false")
+	assert.Nil(t, err)
+	getChatResponse = GetChatResponse{}
+	MustDecode(t, response.Body, &getChatResponse)
+	assert.Empty(t, getChatResponse.Messages[0].Mentions)
+}
+
+// Integration test of unread counts and marking chat as read.
+func TestChatReadPositions(t *testing.T) {
+	port := StartTestService(t)
+
+	owner, _ := NewTestUser(t, port)
+	member, _ := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, owner)
+	JoinTestGroup(t, port, owner, member, groupID)
+	response, err := Patch(member, fmt.Sprintf("http://localhost:%d/api/user/", port), PatchUserRequest{Name: "Robin"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	chatURL := fmt.Sprintf("http://localhost:%d/api/group/%d/chat/", port, groupID)
+	readURL := chatURL + "read/"
+
+	var messageIDs []MessageID
+	for _, content := range []string{"hey @Robin", "hello", "bye"} {
+		response, err := Patch(owner, chatURL, PatchChatRequest{Content: content})
+		assert.Nil(t, err)
+		var patchChatResponse PatchChatResponse
+		MustDecode(t, response.Body, &patchChatResponse)
+		messageIDs = append(messageIDs, patchChatResponse.MessageID)
+	}
+	readUnread := func() []GetUserResponseUnread {
+		response, err := member.Get(fmt.Sprintf("http://localhost:%d/api/user/", port))
+		assert.Nil(t, err)
+		var getUserResponse GetUserResponse
+		MustDecode(t, response.Body, &getUserResponse)
+		return getUserResponse.Unread
+	}
+
+	// Test: everything is unread at first.
+	assert.Equal(t, []GetUserResponseUnread{{GroupID: groupID, Count: 3, Mentioned: true}}, readUnread())
+
+	// Test: reading part of the chat.
+	response, err = Patch(member, readURL, PatchChatReadRequest{MessageID: messageIDs[0]})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, []GetUserResponseUnread{{GroupID: groupID, Count: 2}}, readUnread())
+
+	// Test: reading up to the latest message, which a stale position can't undo.
+	response, err = Patch(member, readURL, PatchChatReadRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Empty(t, readUnread())
+	response, err = Patch(member, readURL, PatchChatReadRequest{MessageID: messageIDs[1]})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Empty(t, readUnread())
+
+	// Test: the member's own messages aren't unread, even if there are many.
+	response, err = Patch(member, chatURL, PatchChatRequest{Content: "back"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Empty(t, readUnread())
+	response, err = Patch(owner, chatURL, PatchChatRequest{Content: "welcome back"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	for i := 0; i < chatMaxUnread; i++ {
+		response, err = Patch(member, chatURL, PatchChatRequest{Content: strconv.Itoa(i)})
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	}
+	assert.Equal(t, []GetUserResponseUnread{{GroupID: groupID, Count: 1}}, readUnread())
+
+	// Test: reading can't go past the latest message.
+	response, err = Patch(member, readURL, PatchChatReadRequest{MessageID: math.MaxUint64})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Empty(t, readUnread())
+	response, err = Patch(owner, chatURL, PatchChatRequest{Content: "bye again"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, []GetUserResponseUnread{{GroupID: groupID, Count: 1}}, readUnread())
+}
+
+func TestChatSearch(t *testing.T) {
+	port := StartTestService(t)
+
+	owner, _ := NewTestUser(t, port)
+	member, memberID := NewTestUser(t, port)
+	outsider, _ := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, owner)
+	JoinTestGroup(t, port, owner, member, groupID)
+	chatURL := fmt.Sprintf("http://localhost:%d/api/group/%d/chat/", port, groupID)
+
+	send := func(c *http.Client, request PatchChatRequest) MessageID {
+		response, err := Patch(c, chatURL, request)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		var patchChatResponse PatchChatResponse
+		MustDecode(t, response.Body, &patchChatResponse)
+		return patchChatResponse.MessageID
 	}
+	search := func(query url.Values) GetChatSearchResponse {
+		response, err := owner.Get(chatURL + "search/?" + query.Encode())
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		var getChatSearchResponse GetChatSearchResponse
+		MustDecode(t, response.Body, &getChatSearchResponse)
+		return getChatSearchResponse
+	}
+
+	firstID := send(owner, PatchChatRequest{Content: "Beach or mountains?"})
+	secondID := send(member, PatchChatRequest{Content: "The beach!"})
+	replyID := send(owner, PatchChatRequest{Content: "beach it is", ParentID: firstID})
+	send(member, PatchChatRequest{Content: "see you there"})
+
+	// Test: matches are latest first, including replies, with highlights.
+	results := search(url.Values{"q": {"BEACH"}})
+	assert.Len(t, results.Results, 3)
+	assert.Empty(t, results.Continue)
+	assert.Equal(t, replyID, results.Results[0].MessageID)
+	assert.Equal(t, firstID, results.Results[0].ParentID)
+	assert.Equal(t, []GetChatSearchResponseSnippet{{Text: "The "}, {Text: "beach", Match: true}, {Text: "!"}}, results.Results[1].Snippet)
+	assert.Equal(t, memberID, results.Results[1].Sender)
+
+	// Test: filtering by sender and time.
+	results = search(url.Values{"q": {"beach"}, "sender": {strconv.FormatUint(memberID, 10)}})
+	assert.Len(t, results.Results, 1)
+	assert.Equal(t, secondID, results.Results[0].MessageID)
+	results = search(url.Values{"q": {"beach"}, "end": {strconv.FormatUint(results.Results[0].Timestamp-1, 10)}})
+	for _, result := range results.Results {
+		assert.Equal(t, firstID, result.MessageID)
+	}
+
+	// Test: paging visits every match once.
+	results = search(url.Values{"q": {"beach"}, "limit": {"2"}})
+	assert.Len(t, results.Results, 2)
+	assert.NotEmpty(t, results.Continue)
+	results = search(url.Values{"q": {"beach"}, "limit": {"2"}, "cursor": {results.Continue}})
+	assert.Len(t, results.Results, 1)
+	assert.Equal(t, firstID, results.Results[0].MessageID)
+	assert.Empty(t, results.Continue)
+
+	// Test: edited and deleted messages are searched by their new content.
+	response, err := Patch(member, fmt.Sprintf("%s%d/", chatURL, secondID), PatchChatMessageRequest{Content: "mountains"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response, err = Delete(owner, fmt.Sprintf("%s%d/", chatURL, firstID))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	results = search(url.Values{"q": {"mountains"}})
+	assert.Len(t, results.Results, 1)
+	assert.Equal(t, secondID, results.Results[0].MessageID)
+
+	// Test: invalid searches.
+	response, err = owner.Get(chatURL + "search/?q=%20!")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	response, err = owner.Get(chatURL + "search/?q=beach%20" + strings.Repeat("a", searchTermMaxLen+1))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	response, err = outsider.Get(chatURL + "search/?q=beach")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+}
+
+// Integration test of system messages recording group events in chat.
+func TestChatSystemMessages(t *testing.T) {
+	port := StartTestService(t)
+
+	owner, ownerID := NewTestUser(t, port)
+	member, memberID := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, owner)
+	JoinTestGroup(t, port, owner, member, groupID)
+	groupURL := fmt.Sprintf("http://localhost:%d/api/group/%d/", port, groupID)
+	chatURL := groupURL + "chat/"
+
+	response, err := Patch(member, groupURL+"poll/", PatchPollsRequest{Title: "where?", Options: []string{"here"}})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response, err = Patch(owner, groupURL+"activity/", PatchActivityRequest{Title: "hike", Date: "9999-09-25", Start: "15:00", End: "16:00"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response, err = Patch(owner, groupURL+"task/", PatchTaskRequest{Title: "snacks"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response, err = Patch(owner, chatURL, PatchChatRequest{Content: "done?"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	completed := true
+	taskURL := fmt.Sprintf("%stask/%d/", groupURL, GetTestGroup(t, port, owner, groupID).Tasks[0].TaskID)
+	for i := 0; i < 2; i++ {
+		response, err = Patch(member, taskURL, PatchTaskRequest{Completed: &completed})
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	}
+	readChat := func(query string) []GetChatResponseMessage {
+		response, err := owner.Get(chatURL + query)
+		assert.Nil(t, err)
+		var getChatResponse GetChatResponse
+		MustDecode(t, response.Body, &getChatResponse)
+		return getChatResponse.Messages
+	}
+
+	// Test: events are in the timeline, attributed to whoever caused them.
+	messages := readChat("")
+	assert.Len(t, messages, 5)
+	var kinds []string
+	for _, message := range messages {
+		kinds = append(kinds, message.Kind)
+	}
+	assert.Equal(t, []string{MessageKindMemberJoined, MessageKindPollCreated, MessageKindActivityCreated, "", MessageKindTaskCompleted}, kinds)
+	assert.Equal(t, memberID, messages[0].Sender)
+	assert.Equal(t, memberID, messages[1].Sender)
+	assert.Equal(t, "where?", messages[1].Content)
+	assert.Equal(t, ownerID, messages[2].Sender)
+	assert.Equal(t, "hike", messages[2].Content)
+	assert.Equal(t, "snacks", messages[4].Content)
+
+	// Test: system messages can be filtered out.
+	messages = readChat("?system=false")
+	assert.Len(t, messages, 1)
+	assert.Equal(t, "done?", messages[0].Content)
+
+	// Test: system messages cannot be edited.
+	response, err = Patch(owner, fmt.Sprintf("%s%d/", chatURL, readChat("")[0].MessageID), PatchChatMessageRequest{Content: "hacked"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func TestPolls(t *testing.T) {
+	port := StartTestService(t)
+
+	owner, ownerID := NewTestUser(t, port)
+	member, memberID := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, owner)
+	JoinTestGroup(t, port, owner, member, groupID)
+	pollURL := fmt.Sprintf("http://localhost:%d/api/group/%d/poll/", port, groupID)
+	createPoll := func(c *http.Client, title string) PollID {
+		response, err := Patch(c, pollURL, PatchPollsRequest{Title: title, Options: []string{"yes", "no"}})
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		var patchPollsResponse PatchPollsResponse
+		MustDecode(t, response.Body, &patchPollsResponse)
+		return patchPollsResponse.PollID
+	}
+	update := func(c *http.Client, pollID PollID, request PatchPollRequest) int {
+		response, err := Patch(c, fmt.Sprintf("%s%d/", pollURL, pollID), request)
+		assert.Nil(t, err)
+		return response.StatusCode
+	}
+	closed := true
+
+	// Test: polls run concurrently, with separate votes.
+	dinner := createPoll(member, "dinner?")
+	movie := createPoll(owner, "movie?")
+	assert.Equal(t, http.StatusOK, update(member, dinner, PatchPollRequest{Votes: []string{"yes"}}))
+	assert.Equal(t, http.StatusOK, update(member, movie, PatchPollRequest{Votes: []string{"no"}}))
+	assert.Equal(t, http.StatusOK, update(owner, dinner, PatchPollRequest{Votes: []string{"yes"}}))
+	polls := GetTestGroup(t, port, owner, groupID).Polls
+	assert.Len(t, polls, 2)
+	assert.Equal(t, dinner, polls[0].PollID)
+	assert.Equal(t, memberID, polls[0].Creator)
+	assert.NotZero(t, polls[0].Timestamp)
+	assert.Equal(t, []UserID{memberID, ownerID}, polls[0].Options[0].Votes)
+	assert.Equal(t, []UserID{memberID}, polls[1].Options[1].Votes)
+
+	// Test: only the creator or an admin can close a poll, which keeps its
+	// results but stops voting.
+	assert.Equal(t, http.StatusUnauthorized, update(member, movie, PatchPollRequest{Closed: &closed}))
+	assert.Equal(t, http.StatusOK, update(owner, dinner, PatchPollRequest{Closed: &closed}))
+	assert.Equal(t, http.StatusBadRequest, update(member, dinner, PatchPollRequest{Votes: []string{"no"}}))
+	polls = GetTestGroup(t, port, member, groupID).Polls
+	assert.True(t, polls[0].Closed)
+	assert.False(t, polls[1].Closed)
+	assert.Equal(t, []UserID{memberID, ownerID}, polls[0].Options[0].Votes)
+
+	// Test: only the creator or an admin can delete a poll, which archives it
+	// with its results.
+	response, err := Delete(member, fmt.Sprintf("%s%d/", pollURL, movie))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	response, err = Delete(owner, fmt.Sprintf("%s%d/", pollURL, movie))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, http.StatusBadRequest, update(owner, movie, PatchPollRequest{Votes: []string{"yes"}}))
+	polls = GetTestGroup(t, port, member, groupID).Polls
+	assert.Len(t, polls, 2)
+	assert.Equal(t, movie, polls[1].PollID)
+	assert.True(t, polls[1].Archived)
+	assert.True(t, polls[1].Closed)
+	assert.Equal(t, []UserID{memberID}, polls[1].Options[1].Votes)
+
+	// Test: archived polls don't count toward the limit, and the oldest closed
+	// poll is archived to make room.
+	for i := 1; i < groupMaxPolls; i++ {
+		createPoll(owner, fmt.Sprintf("poll %d?", i))
+	}
+	createPoll(owner, "one more?")
+	polls = GetTestGroup(t, port, member, groupID).Polls
+	assert.Len(t, polls, groupMaxPolls+2)
+	assert.Equal(t, dinner, polls[0].PollID)
+	assert.True(t, polls[0].Archived)
+	response, err = Patch(owner, pollURL, PatchPollsRequest{Title: "too many?"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+}
+
+func TestChatCommands(t *testing.T) {
+	port := StartTestService(t)
+
+	owner, ownerID := NewTestUser(t, port)
+	member, memberID := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, owner)
+	JoinTestGroup(t, port, owner, member, groupID)
+	response, err := Patch(member, fmt.Sprintf("http://localhost:%d/api/user/", port), PatchUserRequest{Name: "Robin"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	chatURL := fmt.Sprintf("http://localhost:%d/api/group/%d/chat/", port, groupID)
+	// Sends a command, returning its system reply (if any).
+	command := func(content string, status int) *GetChatResponseMessage {
+		response, err := Patch(owner, chatURL, PatchChatRequest{Content: content})
+		assert.Nil(t, err)
+		assert.Equal(t, status, response.StatusCode)
+		if status != http.StatusOK {
+			return nil
+		}
+		var patchChatResponse PatchChatResponse
+		MustDecode(t, response.Body, &patchChatResponse)
+		response, err = owner.Get(fmt.Sprintf("%s%d/thread/", chatURL, patchChatResponse.MessageID))
+		assert.Nil(t, err)
+		var getChatResponse GetChatResponse
+		MustDecode(t, response.Body, &getChatResponse)
+		if len(getChatResponse.Messages) == 0 {
+			return nil
+		}
+		return &getChatResponse.Messages[0]
+	}
+
+	// Test: each command acts on the group and is answered in its thread.
+	reply := command(`/poll "Dinner?" pizza tacos`, http.StatusOK)
+	assert.Equal(t, MessageKindPollCreated, reply.Kind)
+	assert.Equal(t, "Dinner?", reply.Content)
+	assert.Equal(t, ownerID, reply.Sender)
+	reply = command("/task buy snacks @robin", http.StatusOK)
+	assert.Equal(t, MessageKindTaskCreated, reply.Kind)
+	assert.Equal(t, "buy snacks", reply.Content)
+	reply = command("/activity 9999-09-25 18:00-20:00 Movie night", http.StatusOK)
+	assert.Equal(t, MessageKindActivityCreated, reply.Kind)
+	assert.Equal(t, "Movie night", reply.Content)
+	reply = command("/available 9999-09-25 8:00-11:00", http.StatusOK)
+	assert.Equal(t, MessageKindAvailabilityAdded, reply.Kind)
+	assert.Equal(t, "9999-09-25 8:00-11:00", reply.Content)
+
+	group := GetTestGroup(t, port, member, groupID)
+	assert.Len(t, group.Polls, 1)
+	assert.Equal(t, "Dinner?", group.Polls[0].Title)
+	assert.Equal(t, []GetGroupResponsePollOption{{Name: "pizza", Votes: []UserID{}}, {Name: "tacos", Votes: []UserID{}}}, group.Polls[0].Options)
+	assert.Len(t, group.Tasks, 1)
+	assert.Equal(t, memberID, group.Tasks[0].Assignee)
+	assert.Len(t, group.Activities, 1)
+	assert.Equal(t, "18:00", group.Activities[0].Start)
+	assert.Equal(t, "20:00", group.Activities[0].End)
+	assert.Len(t, group.Availabilities, 1)
+	assert.Equal(t, ownerID, group.Availabilities[0].UserID)
+
+	// Test: commands are validated like the API, and nothing is sent if invalid.
+	command("/activity 9999-09-25 18:00 Movie night", http.StatusBadRequest)
+	command("/available tomorrow", http.StatusBadRequest)
+	command(`/poll "Dinner?`, http.StatusBadRequest)
+	command("/task buy snacks @nobody", http.StatusBadRequest)
+	command(`/poll "" pizza`, http.StatusBadRequest)
+	response, err = member.Get(chatURL + "?system=false")
+	assert.Nil(t, err)
+	var getChatResponse GetChatResponse
+	MustDecode(t, response.Body, &getChatResponse)
+	assert.Len(t, getChatResponse.Messages, 4)
+
+	// Test: anything else is just a message.
+	assert.Nil(t, command("/shrug", http.StatusOK))
+}
+
+func TestChatPins(t *testing.T) {
+	port := StartTestService(t)
+
+	owner, ownerID := NewTestUser(t, port)
+	member, memberID := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, owner)
+	JoinTestGroup(t, port, owner, member, groupID)
+	groupURL := fmt.Sprintf("http://localhost:%d/api/group/%d/", port, groupID)
+	chatURL := groupURL + "chat/"
+
+	var messageIDs []MessageID
+	for i := 0; i <= groupMaxPins+1; i++ {
+		response, err := Patch(owner, chatURL, PatchChatRequest{Content: fmt.Sprintf("flight %d", i)})
+		assert.Nil(t, err)
+		var patchChatResponse PatchChatResponse
+		MustDecode(t, response.Body, &patchChatResponse)
+		messageIDs = append(messageIDs, patchChatResponse.MessageID)
+	}
+	pinURL := func(messageID MessageID) string {
+		return fmt.Sprintf("%s%d/pin/", chatURL, messageID)
+	}
+	readPins := func() []GetGroupResponsePin {
+		response, err := member.Get(groupURL)
+		assert.Nil(t, err)
+		var getGroupResponse GetGroupResponse
+		MustDecode(t, response.Body, &getGroupResponse)
+		return getGroupResponse.Pins
+	}
+
+	// Test: any member can pin, and pinning twice has no effect.
+	for _, c := range []*http.Client{member, member} {
+		response, err := Put(c, pinURL(messageIDs[1]), nil)
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	}
+	pins := readPins()
+	assert.Len(t, pins, 1)
+	assert.Equal(t, messageIDs[1], pins[0].MessageID)
+	assert.Equal(t, memberID, pins[0].Pinner)
+	assert.Equal(t, ownerID, pins[0].Sender)
+	assert.Equal(t, "flight 1", pins[0].Content)
+
+	// Test: pins are limited.
+	for i, messageID := range messageIDs[2:] {
+		response, err := Put(owner, pinURL(messageID), nil)
+		assert.Nil(t, err)
+		if 1+i < groupMaxPins {
+			assert.Equal(t, http.StatusOK, response.StatusCode)
+		} else {
+			assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+		}
+	}
+	assert.Len(t, readPins(), groupMaxPins)
+
+	// Test: unpinning, and deleting a pinned message unpins it.
+	response, err := Delete(owner, pinURL(messageIDs[1]))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response, err = Delete(owner, pinURL(messageIDs[1]))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	response, err = Delete(owner, fmt.Sprintf("%s%d/", chatURL, messageIDs[2]))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	pins = readPins()
+	assert.Len(t, pins, groupMaxPins-2)
+	assert.Equal(t, messageIDs[3], pins[0].MessageID)
+
+	// Test: only existing messages can be pinned.
+	response, err = Put(owner, pinURL(messageIDs[2]), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
+
+func TestAnnouncements(t *testing.T) {
+	port := StartTestService(t)
+
+	owner, ownerID := NewTestUser(t, port)
+	member, _ := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, owner)
+	JoinTestGroup(t, port, owner, member, groupID)
+	groupURL := fmt.Sprintf("http://localhost:%d/api/group/%d/", port, groupID)
+	announcementURL := groupURL + "announcement/"
+	readAnnouncements := func() []GetGroupResponseAnnouncement {
+		response, err := member.Get(groupURL)
+		assert.Nil(t, err)
+		var getGroupResponse GetGroupResponse
+		MustDecode(t, response.Body, &getGroupResponse)
+		return getGroupResponse.Announcements
+	}
+
+	// Test: only admins can announce, and expiry must be in the future.
+	response, err := Patch(member, announcementURL, PatchAnnouncementRequest{Content: "hi"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	response, err = Patch(owner, announcementURL, PatchAnnouncementRequest{Content: "hi", Expiry: unixMillis() - 1})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	expiry := unixMillis() + uint64(time.Hour.Milliseconds())
+	response, err = Patch(owner, announcementURL, PatchAnnouncementRequest{Content: "Meet at gate 12", Expiry: expiry})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var patchAnnouncementResponse PatchAnnouncementResponse
+	MustDecode(t, response.Body, &patchAnnouncementResponse)
+	announcements := readAnnouncements()
+	assert.Len(t, announcements, 1)
+	assert.Equal(t, patchAnnouncementResponse.AnnouncementID, announcements[0].AnnouncementID)
+	assert.Equal(t, ownerID, announcements[0].Creator)
+	assert.Equal(t, "Meet at gate 12", announcements[0].Content)
+	assert.Equal(t, expiry, announcements[0].Expiry)
+
+	// Test: only admins can remove announcements.
+	url := fmt.Sprintf("%s%d/", announcementURL, patchAnnouncementResponse.AnnouncementID)
+	response, err = Delete(member, url)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	response, err = Delete(owner, url)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Empty(t, readAnnouncements())
+	response, err = Delete(owner, url)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+}
+
+func TestChatRetention(t *testing.T) {
+	port := StartTestService(t)
+
+	owner, _ := NewTestUser(t, port)
+	member, _ := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, owner)
+	JoinTestGroup(t, port, owner, member, groupID)
+	groupURL := fmt.Sprintf("http://localhost:%d/api/group/%d/", port, groupID)
+	chatURL := groupURL + "chat/"
+	send := func(content string) MessageID {
+		response, err := Patch(member, chatURL, PatchChatRequest{Content: content})
+		assert.Nil(t, err)
+		var patchChatResponse PatchChatResponse
+		MustDecode(t, response.Body, &patchChatResponse)
+		return patchChatResponse.MessageID
+	}
+	readChat := func() []GetChatResponseMessage {
+		response, err := member.Get(chatURL + "?system=false")
+		assert.Nil(t, err)
+		var getChatResponse GetChatResponse
+		MustDecode(t, response.Body, &getChatResponse)
+		return getChatResponse.Messages
+	}
+
+	// Test: only admins can change retention, within limits.
+	days := uint64(1)
+	response, err := Patch(member, groupURL, PatchGroupRequest{RetentionDays: &days})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	tooLong := uint64(groupMaxRetentionDays + 1)
+	response, err = Patch(owner, groupURL, PatchGroupRequest{RetentionDays: &tooLong})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	response, err = Patch(owner, groupURL, PatchGroupRequest{RetentionDays: &days})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, days, GetTestGroup(t, port, member, groupID).RetentionDays)
+
+	// Test: recent messages are kept.
+	first := send("see you at 5")
+	time.Sleep(2 * time.Millisecond)
+	second := send("running late")
+	messages := readChat()
+	assert.Len(t, messages, 2)
+	response, err = Put(member, fmt.Sprintf("%s%d/pin/", chatURL, first), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	// Test: only admins can purge, up to a time.
+	response, err = Delete(member, chatURL)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+	response, err = Delete(owner, fmt.Sprintf("%s?end=%d", chatURL, messages[0].Timestamp))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	messages = readChat()
+	assert.Len(t, messages, 1)
+	assert.Equal(t, second, messages[0].MessageID)
+	assert.Empty(t, GetTestGroup(t, port, member, groupID).Pins)
+
+	response, err = Delete(owner, chatURL)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Empty(t, readChat())
+}
+
+// Test that chat messages are deleted once they are older than the group's
+// retention setting allows.
+func TestChatRetentionEnforced(t *testing.T) {
+	database := NewMemoryDatabase()
+	notification := NewLocalNotification()
+	now := time.Now()
+	group := Group{GroupID: GenerateID(), RetentionDays: 1}
+	assert.Nil(t, database.CreateGroup(group))
+	sent := func(ago time.Duration) UnixMillis {
+		return UnixMillis(now.Add(-ago).UnixMilli())
+	}
+	// Sent before the retention setting changed, so they have no expiry.
+	assert.Nil(t, database.CreateMessage(Message{GroupID: group.GroupID, MessageID: lastMessageID(sent(48 * time.Hour)), Content: "old"}))
+	assert.Nil(t, database.CreateMessage(Message{GroupID: group.GroupID, MessageID: lastMessageID(sent(time.Hour)), Content: "new"}))
+	// Sent after, so they expire without activation.
+	message := Message{GroupID: group.GroupID, MessageID: lastMessageID(sent(time.Minute)), Timestamp: sent(time.Minute), Content: "newer"}
+	message.Expiry = group.MessageExpiry(message.Timestamp)
+	assert.Nil(t, database.CreateMessage(message))
+	readContents := func() []string {
+		messages, _, err := database.ReadMessages(group.GroupID, 0, 0, math.MaxUint64, 100, true, true)
+		assert.Nil(t, err)
+		var contents []string
+		for _, message := range messages {
+			contents = append(contents, message.Content)
+		}
+		return contents
+	}
+
+	assert.Nil(t, activateAt(Activation{GroupID: &group.GroupID}, now, database, notification))
+	assert.Equal(t, []string{"new", "newer"}, readContents())
+	assert.Nil(t, database.DeleteExpiredMessages(now.Add(24*time.Hour)))
+	assert.Equal(t, []string{"new"}, readContents())
+	assert.Nil(t, activateAt(Activation{GroupID: &group.GroupID}, now.Add(24*time.Hour), database, notification))
+	assert.Empty(t, readContents())
+}
+
+// Records notifications instead of sending them.
+type recordingNotification struct {
+	data []any
+	mu   sync.Mutex
+}
+
+func (notification *recordingNotification) Notify(connectionID ConnectionID, data any) error {
+	notification.mu.Lock()
+	defer notification.mu.Unlock()
+	notification.data = append(notification.data, data)
+	return nil
+}
+
+// End-to-end test that the local service's scheduled jobs follow its clock, by
+// enabling retention and skipping ahead until old messages are deleted.
+func TestLocalServiceClock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	port := StartTestServiceWithClock(t, clock)
+
+	owner, _ := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, owner)
+	groupURL := fmt.Sprintf("http://localhost:%d/api/group/%d/", port, groupID)
+	chatURL := groupURL + "chat/"
+	readChat := func() []GetChatResponseMessage {
+		response, err := owner.Get(chatURL + "?system=false")
+		assert.Nil(t, err)
+		var getChatResponse GetChatResponse
+		MustDecode(t, response.Body, &getChatResponse)
+		return getChatResponse.Messages
+	}
+
+	// Advance an hour at a time, waiting for the background goroutine to
+	// finish running cron jobs and start waiting again each time.
+	advance := func(d time.Duration) {
+		for end := clock.Now().Add(d); clock.Now().Before(end); {
+			clock.WaitForTimer(t)
+			clock.Advance(time.Hour)
+		}
+		clock.WaitForTimer(t)
+	}
+
+	// Sent before retention is enabled, so only deleted by a cron job.
+	response, err := Patch(owner, chatURL, PatchChatRequest{Content: "old news"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	days := uint64(1)
+	response, err = Patch(owner, groupURL, PatchGroupRequest{RetentionDays: &days})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	advance(time.Hour)
+	assert.Len(t, readChat(), 1)
+	advance(retentionPeriod(days))
+	assert.Empty(t, readChat())
+
+	// Test: keeping messages forever applies to those already sent.
+	response, err = Patch(owner, chatURL, PatchChatRequest{Content: "big news"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	days = 0
+	response, err = Patch(owner, groupURL, PatchGroupRequest{RetentionDays: &days})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	advance(2 * retentionPeriod(1))
+	assert.Len(t, readChat(), 1)
+}
+
+// Test that members are only told the chat was cleared if messages were
+// deleted.
+func TestChatRetentionNotification(t *testing.T) {
+	database := NewMemoryDatabase()
+	notification := &recordingNotification{}
+	now := time.Now()
+	userID := GenerateID()
+	group := Group{GroupID: GenerateID(), Members: []UserID{userID}, RetentionDays: 1}
+	assert.Nil(t, database.CreateUser(User{UserID: userID, Groups: []GroupID{group.GroupID}, Connections: []ConnectionID{"abcd"}}))
+	assert.Nil(t, database.CreateGroup(group))
+	assert.Nil(t, database.CreateMessage(Message{GroupID: group.GroupID, MessageID: lastMessageID(UnixMillis(now.Add(-48 * time.Hour).UnixMilli())), Content: "old"}))
+	cleared := func() int {
+		notification.mu.Lock()
+		defer notification.mu.Unlock()
+		count := 0
+		for _, data := range notification.data {
+			if _, ok := data.(ChatCleared); ok {
+				count++
+			}
+		}
+		return count
+	}
+
+	assert.Nil(t, activateAt(Activation{GroupID: &group.GroupID}, now, database, notification))
+	assert.Equal(t, 1, cleared())
+	assert.Nil(t, activateAt(Activation{GroupID: &group.GroupID}, now, database, notification))
+	assert.Equal(t, 1, cleared())
+}
+
+// Test that announcements are removed once they expire.
+func TestAnnouncementExpiry(t *testing.T) {
+	database := NewMemoryDatabase()
+	clock := NewFakeClock(time.Now())
+	scheduler, err := NewLocalScheduler(database, NewLocalNotification(), clock)
+	assert.Nil(t, err)
+	groupID := GenerateID()
+	expiry := UnixMillis(clock.Now().Add(time.Hour).UnixMilli())
+	announcement := Announcement{AnnouncementID: 1, Content: "soon", Expiry: expiry}
+	announcement.ExpiryJob = scheduleAnnouncementExpiry(groupID, announcement, scheduler)
+	assert.NotEmpty(t, announcement.ExpiryJob)
+	assert.Nil(t, database.CreateGroup(Group{
+		GroupID:       groupID,
+		Announcements: []Announcement{announcement, {AnnouncementID: 2, Content: "forever"}},
+	}))
+	announcementIDs := func() []AnnouncementID {
+		group, err := database.ReadGroup(groupID)
+		assert.Nil(t, err)
+		var ids []AnnouncementID
+		for _, announcement := range group.Announcements {
+			ids = append(ids, announcement.AnnouncementID)
+		}
+		return ids
+	}
+
+	clock.Advance(time.Hour - time.Second)
+	assert.Equal(t, []AnnouncementID{1, 2}, announcementIDs())
+	clock.Advance(time.Minute)
+	assert.Equal(t, []AnnouncementID{2}, announcementIDs())
+}
+
+// Integration test of messages sent by clients over WebSocket.
+func TestWebSocketMessages(t *testing.T) {
+	port := StartTestService(t)
+
+	owner, ownerID := NewTestUser(t, port)
+	member, _ := NewTestUser(t, port)
+	groupID := NewTestGroup(t, port, owner)
+	JoinTestGroup(t, port, owner, member, groupID)
+
+	dial := func(c *http.Client) *websocket.Conn {
+		dialer := websocket.Dialer{Jar: c.Jar}
+		ws, _, err := dialer.Dial(fmt.Sprintf("ws://localhost:%d/ws/", port), nil)
+		assert.Nil(t, err)
+		return ws
+	}
+	// Reads notifications until one has the given key, remembering the keys
+	// of those skipped.
+	var skipped []string
+	readUntil := func(ws *websocket.Conn, key string) json.RawMessage {
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			var notification map[string]json.RawMessage
+			if err := ws.ReadJSON(&notification); err != nil {
+				t.Fatalf("did not receive %s: %v", key, err)
+			}
+			if value, ok := notification[key]; ok {
+				return value
+			}
+			for skippedKey := range notification {
+				skipped = append(skipped, skippedKey)
+			}
+		}
+	}
+	ownerWS := dial(owner)
+	defer ownerWS.Close()
+	memberWS := dial(member)
+	defer memberWS.Close()
+
+	// Test: heartbeat.
+	assert.Nil(t, ownerWS.WriteJSON(WebSocketRequest{Action: "ping"}))
+	var pong HeartbeatAcknowledgedPong
+	assert.Nil(t, json.Unmarshal(readUntil(ownerWS, "pong"), &pong))
+	assert.NotZero(t, pong.Timestamp)
+
+	// Test: typing goes to other members, and invalid messages are ignored.
+	assert.Nil(t, ownerWS.WriteMessage(websocket.TextMessage, []byte("sus")))
+	assert.Nil(t, ownerWS.WriteJSON(map[string]any{"action": "typing", "groupId": groupID}))
+	var typing MemberTypingTyping
+	assert.Nil(t, json.Unmarshal(readUntil(memberWS, "typing"), &typing))
+	assert.Equal(t, groupID, typing.GroupID)
+	assert.Equal(t, ownerID, typing.UserID)
+	assert.Greater(t, typing.Expiry, unixMillis())
+	skipped = nil
+	assert.Nil(t, ownerWS.WriteJSON(WebSocketRequest{Action: "ping"}))
+	readUntil(ownerWS, "pong")
+	assert.NotContains(t, skipped, "typing")
+}
+
+// Test that activations delete abandoned groups, but only once enough time has passed.
+func TestActivateDeletesAbandonedGroup(t *testing.T) {
+	database := NewMemoryDatabase()
+	groupID := GenerateID()
+	archived := time.Now().Add(-groupDeletionDelay / 2)
+	assert.Nil(t, database.CreateGroup(Group{GroupID: groupID, Archived: uint64(archived.UnixMilli())}))
+	assert.Nil(t, database.CreateMessage(Message{GroupID: groupID, MessageID: 1, Content: "hi"}))
+
+	assert.Nil(t, Activate(Activation{GroupID: &groupID}, database, NewLocalNotification()))
+	group, err := database.ReadGroup(groupID)
+	assert.Nil(t, err)
+	assert.NotNil(t, group)
+
+	assert.Nil(t, database.UpdateGroup(groupID, func(group *Group) error {
+		group.Archived = uint64(archived.Add(-groupDeletionDelay).UnixMilli())
+		return nil
+	}))
+	assert.Nil(t, Activate(Activation{GroupID: &groupID}, database, NewLocalNotification()))
+	group, err = database.ReadGroup(groupID)
+	assert.Nil(t, err)
+	assert.Nil(t, group)
+	messages, _, err := database.ReadMessages(groupID, 0, 0, math.MaxUint64, 100, false, true)
+	assert.Nil(t, err)
+	assert.Empty(t, messages)
+
+	// Test: activation is idempotent.
+	assert.Nil(t, Activate(Activation{GroupID: &groupID}, database, NewLocalNotification()))
+}
+
+// Test that activations send each reminder exactly once.
+func TestActivateSendsReminders(t *testing.T) {
+	database := NewMemoryDatabase()
+	notification := NewLocalNotification()
+	userID := GenerateID()
+	groupID := GenerateID()
+	soon := time.Now().Add(activityReminderLead / 2)
+	later := time.Now().Add(activityReminderLead * 2)
+	assert.Nil(t, database.CreateUser(User{UserID: userID, Groups: []GroupID{groupID}}))
+	assert.Nil(t, database.CreateGroup(Group{
+		GroupID: groupID,
+		Members: []UserID{userID},
+		Activities: []Activity{
+			{ActivityID: 1, Title: "soon", Date: soon.Format(time.DateOnly), Start: soon.Format("15:04")},
+			{ActivityID: 2, Title: "later", Date: later.Format(time.DateOnly), Start: later.Format("15:04")},
+		},
+		Tasks: []Task{
+			{TaskID: 3, Title: "due", Assignee: userID, Due: time.Now().Format(time.DateOnly)},
+			{TaskID: 4, Title: "done", Assignee: userID, Due: time.Now().Format(time.DateOnly), Completed: true},
+			{TaskID: 5, Title: "undated", Assignee: userID},
+		},
+	}))
+
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, Activate(Activation{UserID: &userID}, database, notification))
+		group, err := database.ReadGroup(groupID)
+		assert.Nil(t, err)
+		assert.Equal(t, []UserID{userID}, group.Activities[0].Reminded)
+		assert.Empty(t, group.Activities[1].Reminded)
+		assert.True(t, group.Tasks[0].Reminded)
+		assert.False(t, group.Tasks[1].Reminded)
+		assert.False(t, group.Tasks[2].Reminded)
+	}
+}
+
+// Test that jobs can be listed, rescheduled, and canceled before they run.
+func TestLocalSchedulerJobs(t *testing.T) {
+	database := NewMemoryDatabase()
+	clock := NewFakeClock(time.Now())
+	scheduler, err := NewLocalScheduler(database, NewLocalNotification(), clock)
+	assert.Nil(t, err)
+	groupID := GenerateID()
+
+	jobID, err := scheduler.Schedule(clock.Now().Add(time.Hour), Activation{GroupID: &groupID})
+	assert.Nil(t, err)
+	jobs, err := scheduler.List()
+	assert.Nil(t, err)
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, jobID, jobs[0].JobID)
+	assert.Equal(t, jobID, jobs[0].Activation.JobID)
+
+	date := clock.Now().Add(2 * time.Hour)
+	assert.Nil(t, scheduler.Reschedule(jobID, date))
+	job, err := database.ReadJob(jobID)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(date.UnixMilli()), job.Date)
+
+	// Test: the job doesn't run at its original date.
+	clock.Advance(90 * time.Minute)
+	job, err = database.ReadJob(jobID)
+	assert.Nil(t, err)
+	assert.NotNil(t, job)
+
+	assert.Nil(t, scheduler.Cancel(jobID))
+	jobs, err = scheduler.List()
+	assert.Nil(t, err)
+	assert.Empty(t, jobs)
+	assert.NotNil(t, scheduler.Reschedule(jobID, date))
+
+	// Test: a job that runs is forgotten.
+	jobID, err = scheduler.Schedule(clock.Now().Add(time.Minute), Activation{GroupID: &groupID})
+	assert.Nil(t, err)
+	clock.Advance(time.Minute)
+	job, err = database.ReadJob(jobID)
+	assert.Nil(t, err)
+	assert.Nil(t, job)
+}
+
+// Test that pending jobs survive a restart and run at the right time.
+func TestLocalSchedulerRestart(t *testing.T) {
+	database := NewMemoryDatabase()
+	notification := NewLocalNotification()
+	clock := NewFakeClock(time.Now())
+	userID := GenerateID()
+	groupID := GenerateID()
+	start := clock.Now().Add(3 * activityReminderLead)
+	assert.Nil(t, database.CreateGroup(Group{
+		GroupID: groupID,
+		Members: []UserID{userID},
+		Activities: []Activity{
+			{ActivityID: 1, Title: "later", Date: start.Format(time.DateOnly), Start: start.Format("15:04")},
+		},
+	}))
+	reminded := func() bool {
+		group, err := database.ReadGroup(groupID)
+		assert.Nil(t, err)
+		return len(group.Activities[0].Reminded) > 0
+	}
+
+	// The first instance never sees time pass, as though it were stopped.
+	scheduler, err := NewLocalScheduler(database, notification, NewFakeClock(clock.Now()))
+	assert.Nil(t, err)
+	_, err = scheduler.Schedule(start.Add(-activityReminderLead), Activation{GroupID: &groupID})
+	assert.Nil(t, err)
+
+	_, err = NewLocalScheduler(database, notification, clock)
+	assert.Nil(t, err)
+	clock.Advance(time.Hour)
+	assert.False(t, reminded())
+	clock.Advance(time.Hour)
+	assert.True(t, reminded())
+	jobs, err := database.ReadJobs()
+	assert.Nil(t, err)
+	assert.Empty(t, jobs)
+}
+
+// Test that the hourly cron job reminds members of every group, not just those
+// with scheduled activations.
+func TestActivateCronJob(t *testing.T) {
+	database := NewMemoryDatabase()
+	userID := GenerateID()
+	groupID := GenerateID()
+	assert.Nil(t, database.CreateGroup(Group{
+		GroupID: groupID,
+		Members: []UserID{userID},
+		Tasks:   []Task{{TaskID: 1, Title: "due", Assignee: userID, Due: time.Now().Format(time.DateOnly)}},
+	}))
+
+	assert.Nil(t, findCronJob("activate").Run(time.Now(), database, NewLocalNotification()))
+	group, err := database.ReadGroup(groupID)
+	assert.Nil(t, err)
+	assert.True(t, group.Tasks[0].Reminded)
+}
+
+// Counts group updates, so tests can check that nothing was written.
+type countingDatabase struct {
+	Database
+	groupUpdates int
+}
+
+func (database *countingDatabase) UpdateGroup(groupID GroupID, transaction func(*Group) error) error {
+	database.groupUpdates++
+	return database.Database.UpdateGroup(groupID, transaction)
+}
+
+// Test that activations only update groups with reminders due or
+// announcements expired.
+func TestActivationUpdates(t *testing.T) {
+	database := &countingDatabase{Database: NewMemoryDatabase()}
+	userID := GenerateID()
+	groupID := GenerateID()
+	now := time.Now()
+	assert.Nil(t, database.CreateGroup(Group{
+		GroupID:       groupID,
+		Members:       []UserID{userID},
+		Tasks:         []Task{{TaskID: 1, Title: "later", Assignee: userID, Due: now.AddDate(0, 0, 7).Format(time.DateOnly)}},
+		Announcements: []Announcement{{AnnouncementID: 1, Content: "soon", Expiry: UnixMillis(now.Add(time.Hour).UnixMilli())}},
+	}))
+
+	assert.Nil(t, activateAt(Activation{GroupID: &groupID}, now, database, NewLocalNotification()))
+	assert.Zero(t, database.groupUpdates)
+
+	assert.Nil(t, activateAt(Activation{GroupID: &groupID}, now.AddDate(0, 0, 7), database, NewLocalNotification()))
+	assert.Equal(t, 2, database.groupUpdates)
+	group, err := database.ReadGroup(groupID)
+	assert.Nil(t, err)
+	assert.True(t, group.Tasks[0].Reminded)
+	assert.Empty(t, group.Announcements)
+
+	assert.Nil(t, activateAt(Activation{GroupID: &groupID}, now.AddDate(0, 0, 7), database, NewLocalNotification()))
+	assert.Equal(t, 2, database.groupUpdates)
+}
+
+// Test that cron jobs run whenever their schedule matches.
+func TestCronJobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock := NewFakeClock(time.Date(2024, 2, 15, 19, 10, 30, 0, time.UTC))
+	ran := make(chan time.Time, 16)
+	job := CronJob{
+		Name:     "test",
+		Schedule: "*/20 * * * ?",
+		Run: func(now time.Time, database Database, notification Notification) error {
+			ran <- now
+			return nil
+		},
+	}
+	go runCronJobs(ctx, []CronJob{job}, clock, NewMemoryDatabase(), NewLocalNotification())
+
+	// Advance a minute at a time, waiting for the background goroutine to
+	// finish running jobs and start waiting again each time.
+	var dates []time.Time
+	for i := 0; i <= 60; i++ {
+		clock.WaitForTimer(t)
+		for len(ran) > 0 {
+			dates = append(dates, <-ran)
+		}
+		clock.Advance(time.Minute)
+	}
+	assert.Equal(t, []time.Time{
+		time.Date(2024, 2, 15, 19, 20, 0, 0, time.UTC),
+		time.Date(2024, 2, 15, 19, 40, 0, 0, time.UTC),
+		time.Date(2024, 2, 15, 20, 0, 0, 0, time.UTC),
+	}, dates)
+}
+
+// Integration test of Lambda handler.
+func TestLambdaHandler(t *testing.T) {
+	type Case struct {
+		request  json.RawMessage
+		response json.RawMessage
+		err      error
+	}
+
+	tests := []Case{
+		{
+			request:  MustMarshal(t, &events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet, Path: "/"}),
+			response: json.RawMessage("404 page not found\n"),
+			err:      nil,
+		},
+		{
+			request: MustMarshal(t, &events.APIGatewayWebsocketProxyRequest{
+				RequestContext: events.APIGatewayWebsocketProxyRequestContext{ConnectionID: "abcd", EventType: "MESSAGE"},
+				Body:           `{"action": "ping"}`,
+			}),
+			response: json.RawMessage(""),
+			err:      nil,
+		},
+		{
+			request: MustMarshal(t, &events.APIGatewayWebsocketProxyRequest{
+				RequestContext: events.APIGatewayWebsocketProxyRequestContext{ConnectionID: "abcd", EventType: "MESSAGE"},
+				Body:           `{"action": "sus"}`,
+			}),
+			response: json.RawMessage("unknown action"),
+			err:      nil,
+		},
+		{
+			request: MustMarshal(t, &events.APIGatewayWebsocketProxyRequest{
+				RequestContext: events.APIGatewayWebsocketProxyRequestContext{ConnectionID: "abcd", EventType: "MESSAGE"},
+				Body:           `{"action": "typing", "groupId": "sus"}`,
+			}),
+			response: json.RawMessage("could not decode message: json: cannot unmarshal string into Go struct field TypingRequest.groupId of type uint64"),
+			err:      nil,
+		},
+		{
+			// Not a client error, as the connection should have been written
+			// when it connected.
+			request: MustMarshal(t, &events.APIGatewayWebsocketProxyRequest{
+				RequestContext: events.APIGatewayWebsocketProxyRequestContext{ConnectionID: "abcd", EventType: "MESSAGE"},
+				Body:           `{"action": "typing", "groupId": 1234}`,
+			}),
+			response: json.RawMessage("could not handle message"),
+			err:      nil,
+		},
+		{
+			request:  MustMarshal(t, &events.EventBridgeEvent{DetailType: "activate", Detail: json.RawMessage("{}")}),
+			response: json.RawMessage(""),
+			err:      nil,
+		},
+	}
+
+	for _, test := range tests {
+		database := NewMemoryDatabase()
+		notification := NewLocalNotification()
+		scheduler, err := NewLocalScheduler(database, notification, SystemClock{})
+		if err != nil {
+			panic(err)
+		}
+		context := context.Background()
+		json, err := json.Marshal(test.request)
+		if err != nil {
+			panic(err)
+		}
+		response, err := newLambdaHandler(database, notification, scheduler)(context, json)
+		assert.IsType(t, test.err, err)
+		assert.Equal(t, string(test.response), response.Body)
+	}
+}
+
+// A clock that only moves when told to.
+type FakeClock struct {
+	now    time.Time
+	timers []*FakeTimer
+	mu     sync.Mutex
+}
+
+// A pending call from a fake clock.
+type FakeTimer struct {
+	date    time.Time
+	f       func()
+	stopped bool
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (clock *FakeClock) Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.now
+}
+
+// Unlike a real timer, the call never happens until the clock is advanced.
+func (clock *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	timer := &FakeTimer{date: clock.now.Add(d), f: f}
+	clock.timers = append(clock.timers, timer)
+	return timer
+}
+
+// Waits until a call is pending, such as when a background goroutine is
+// waiting for the clock.
+func (clock *FakeClock) WaitForTimer(t *testing.T) {
+	assert.Eventually(t, func() bool {
+		clock.mu.Lock()
+		defer clock.mu.Unlock()
+		return len(clock.timers) > 0
+	}, time.Second, time.Millisecond)
+}
+
+// Moves the clock forward, synchronously making due calls in order of date.
+func (clock *FakeClock) Advance(d time.Duration) {
+	clock.mu.Lock()
+	clock.now = clock.now.Add(d)
+	var due []*FakeTimer
+	clock.timers = slices.DeleteFunc(clock.timers, func(timer *FakeTimer) bool {
+		if timer.stopped {
+			return true
+		}
+		if timer.date.After(clock.now) {
+			return false
+		}
+		due = append(due, timer)
+		return true
+	})
+	clock.mu.Unlock()
+	slices.SortStableFunc(due, func(a, b *FakeTimer) int {
+		return a.date.Compare(b.date)
+	})
+	for _, timer := range due {
+		if !timer.stopped {
+			timer.stopped = true
+			timer.f()
+		}
+	}
+}
+
+func (timer *FakeTimer) Stop() bool {
+	stopped := timer.stopped
+	timer.stopped = true
+	return !stopped
+}
+
+// Find a port to run the local service on. Random ports may already be in use,
+// like by the clients of other tests.
+func FreeTestPort(t *testing.T) uint16 {
+	listener, err := net.Listen("tcp", ":0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Run the local service on a free port until the test ends, and wait until it
+// accepts connections.
+func StartTestService(t *testing.T) uint16 {
+	return StartTestServiceWithClock(t, SystemClock{})
+}
+
+// Like `StartTestService`, but with a clock the test controls.
+func StartTestServiceWithClock(t *testing.T, clock Clock) uint16 {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	port := FreeTestPort(t)
+	go runLocalService(port, clock, ctx)
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	return port
+}
+
+// Create a client authenticated as a new user.
+func NewTestUser(t *testing.T, port uint16) (*http.Client, UserID) {
+	jar, err := cookiejar.New(nil)
+	assert.Nil(t, err)
+	c := &http.Client{Jar: jar}
+	response, err := c.Get(fmt.Sprintf("http://localhost:%d/api/user/", port))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var getUserResponse GetUserResponse
+	MustDecode(t, response.Body, &getUserResponse)
+	return c, getUserResponse.UserID
+}
+
+// Create a group owned by the client's user.
+func NewTestGroup(t *testing.T, port uint16, c *http.Client) GroupID {
+	response, err := Patch(c, fmt.Sprintf("http://localhost:%d/api/group/", port), PatchGroupRequest{Name: "test"})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var patchGroupResponse PatchGroupResponse
+	MustDecode(t, response.Body, &patchGroupResponse)
+	return patchGroupResponse.GroupID
+}
+
+// Have the member join a group via an invite from the inviter.
+func JoinTestGroup(t *testing.T, port uint16, inviter *http.Client, member *http.Client, groupID GroupID) {
+	response, err := Patch(inviter, fmt.Sprintf("http://localhost:%d/api/group/%d/invite/", port, groupID), PatchInviteRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var patchInviteResponse PatchInviteResponse
+	MustDecode(t, response.Body, &patchInviteResponse)
+	response, err = Patch(member, fmt.Sprintf("http://localhost:%d/api/group/%d/join/", port, groupID), PatchJoinRequest{Token: patchInviteResponse.Token})
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+}
+
+// Read a group the client's user is a member of.
+func GetTestGroup(t *testing.T, port uint16, c *http.Client, groupID GroupID) GetGroupResponse {
+	response, err := c.Get(fmt.Sprintf("http://localhost:%d/api/group/%d/", port, groupID))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var getGroupResponse GetGroupResponse
+	MustDecode(t, response.Body, &getGroupResponse)
+	return getGroupResponse
 }
 
 // Send a patch request via the client.
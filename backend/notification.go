@@ -45,10 +45,98 @@ type MessageReceived struct {
 
 // The chat message that should be received.
 type MessageReceivedMessage struct {
-	GroupID   GroupID `json:"groupId"`
-	Timestamp uint64  `json:"timestamp"`
-	Sender    UserID  `json:"sender"`
-	Content   string  `json:"content"`
+	GroupID   GroupID   `json:"groupId"`
+	MessageID MessageID `json:"messageId"`
+	Timestamp uint64    `json:"timestamp"`
+	Sender    UserID    `json:"sender"`
+	Content   string    `json:"content"`
+	// The kind of system message, if it is one.
+	Kind string `json:"kind,omitempty"`
+	// The message this replies to, if it is in a thread.
+	ParentID MessageID `json:"parentId,omitempty"`
+	Mentions []UserID  `json:"mentions,omitempty"`
+}
+
+// Notification that a chat message was edited.
+type MessageEdited struct {
+	Message MessageEditedMessage `json:"messageEdited"`
+}
+
+// The new content of the chat message.
+type MessageEditedMessage struct {
+	GroupID   GroupID   `json:"groupId"`
+	MessageID MessageID `json:"messageId"`
+	EditedAt  uint64    `json:"editedAt"`
+	Content   string    `json:"content"`
+	Mentions  []UserID  `json:"mentions,omitempty"`
+}
+
+// Notification that a chat message was deleted.
+type MessageDeleted struct {
+	Message MessageDeletedMessage `json:"messageDeleted"`
+}
+
+// The chat message that was deleted.
+type MessageDeletedMessage struct {
+	GroupID   GroupID   `json:"groupId"`
+	MessageID MessageID `json:"messageId"`
+}
+
+// Notification that a group's chat messages were purged.
+type ChatCleared struct {
+	Cleared ChatClearedChat `json:"cleared"`
+}
+
+// The latest chat message purged (along with all earlier ones).
+type ChatClearedChat struct {
+	GroupID   GroupID   `json:"groupId"`
+	MessageID MessageID `json:"messageId"`
+}
+
+// Notification that the reactions to a chat message changed.
+type ReactionChanged struct {
+	Reaction ReactionChangedReaction `json:"reaction"`
+}
+
+// The members that now have a particular reaction to the chat message.
+type ReactionChangedReaction struct {
+	GroupID   GroupID   `json:"groupId"`
+	MessageID MessageID `json:"messageId"`
+	Emoji     string    `json:"emoji"`
+	Users     []UserID  `json:"users"`
+}
+
+// Notification that the client's user read a group's chat (perhaps on another
+// connection).
+type ReadChanged struct {
+	Read ReadChangedRead `json:"read"`
+}
+
+// The latest chat message the user has read.
+type ReadChangedRead struct {
+	GroupID   GroupID   `json:"groupId"`
+	MessageID MessageID `json:"messageId"`
+}
+
+// Notification that the client's user was removed from a group.
+type MemberRemoved struct {
+	Removed MemberRemovedGroup `json:"removed"`
+}
+
+// The group the user was removed from.
+type MemberRemovedGroup struct {
+	GroupID GroupID `json:"groupId"`
+	Banned  bool    `json:"banned"`
+}
+
+// Notification that a group the client's user was in has been deleted.
+type GroupDeleted struct {
+	Deleted GroupDeletedGroup `json:"deleted"`
+}
+
+// The group that was deleted.
+type GroupDeletedGroup struct {
+	GroupID GroupID `json:"groupId"`
 }
 
 // Send a best-effort notification to all group members.
@@ -71,21 +159,26 @@ func notifyGroup(group *Group, data any, database Database, notification Notific
 		wait.Add(1)
 		go func() {
 			defer wait.Done()
-			user, err := database.ReadUser(userID)
-			if err != nil || user == nil {
-				// Ignore errors as notification is best-effort.
-				return
-			}
-			// Update all a member's connections serially.
-			for _, connectionID := range user.Connections {
-				// Ignore errors as notification is best-effort.
-				_ = notification.Notify(connectionID, dataOrGroupChanged)
-			}
+			notifyUser(userID, dataOrGroupChanged, database, notification)
 		}()
 	}
 	wait.Wait()
 }
 
+// Send a best-effort notification to all of a user's connections.
+func notifyUser(userID UserID, data any, database Database, notification Notification) {
+	user, err := database.ReadUser(userID)
+	if err != nil || user == nil {
+		// Ignore errors as notification is best-effort.
+		return
+	}
+	// Update all a user's connections serially.
+	for _, connectionID := range user.Connections {
+		// Ignore errors as notification is best-effort.
+		_ = notification.Notify(connectionID, data)
+	}
+}
+
 // Update a group (like `Database.UpdateGroup`) and notify the members
 // (like `Notification>NotifyGroup`)
 //
@@ -96,6 +189,8 @@ func updateAndNotifyGroup(groupID GroupID, transaction func(*Group) error, datab
 	var g *Group = nil
 	err := database.UpdateGroup(groupID, func(group *Group) error {
 		g = group
+		// Give groups created before roles existed an owner.
+		group.ensureOwner()
 		return transaction(group)
 	})
 	if err != nil {
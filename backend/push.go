@@ -31,6 +31,43 @@ type MessagePushedMessage struct {
 	Content   string `json:"content"`
 }
 
+// Pushed urgently to members mentioned in a chat message.
+type MentionPushed struct {
+	Mention MentionPushedMention `json:"mention"`
+}
+
+type MentionPushedMention struct {
+	Group     string `json:"group"`
+	Timestamp uint64 `json:"timestamp"`
+	Sender    string `json:"sender"`
+	Content   string `json:"content"`
+}
+
+func (MentionPushed) urgency() webpush.Urgency {
+	return webpush.UrgencyHigh
+}
+
+// Pushed urgently to all members when an admin makes an announcement.
+type AnnouncementPushed struct {
+	Announcement AnnouncementPushedAnnouncement `json:"announcement"`
+}
+
+type AnnouncementPushedAnnouncement struct {
+	Group     string `json:"group"`
+	Timestamp uint64 `json:"timestamp"`
+	Sender    string `json:"sender"`
+	Content   string `json:"content"`
+}
+
+func (AnnouncementPushed) urgency() webpush.Urgency {
+	return webpush.UrgencyHigh
+}
+
+// Implemented by pushes that aren't of normal urgency.
+type urgentPush interface {
+	urgency() webpush.Urgency
+}
+
 type ReminderPushed struct {
 	Reminder ReminderPushedReminder `json:"reminder"`
 }
@@ -50,21 +87,32 @@ func pushGroup(group *Group, data any, database Database) {
 		wait.Add(1)
 		go func() {
 			defer wait.Done()
-			user, err := database.ReadUser(userID)
-			if err != nil || user == nil {
-				// Ignore errors as notification is best-effort.
-				return
-			}
-			// Update all a member's connections serially.
-			for _, subscription := range user.Subscriptions {
-				// Ignore errors as notification is best-effort.
-				_ = webPush(data, subscription, database)
-			}
+			pushUser(userID, data, database)
 		}()
 	}
 	wait.Wait()
 }
 
+// Send a best-effort push notification to all of a user's subscriptions.
+func pushUser(userID UserID, data any, database Database) {
+	user, err := database.ReadUser(userID)
+	if err != nil || user == nil {
+		// Ignore errors as notification is best-effort.
+		return
+	}
+	pushSubscriptions(user, data, database)
+}
+
+// Send a best-effort push notification to all of a user's subscriptions, as
+// of when the user was read.
+func pushSubscriptions(user *User, data any, database Database) {
+	// Update all a user's subscriptions serially.
+	for _, subscription := range user.Subscriptions {
+		// Ignore errors as notification is best-effort.
+		_ = webPush(data, subscription, database)
+	}
+}
+
 func RestPushAPI(router *mux.Router, database Database, notification Notification) {
 	router.Use(AuthenticateMiddleware(database))
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -109,10 +157,14 @@ func webPush(data any, subscription webpush.Subscription, database Database) err
 	if err != nil {
 		return fmt.Errorf("could not get VAPID keys: %w", err)
 	}
+	urgency := webpush.UrgencyNormal
+	if urgent, ok := data.(urgentPush); ok {
+		urgency = urgent.urgency()
+	}
 	resp, err := webpush.SendNotification(json, &subscription, &webpush.Options{
 		VAPIDPrivateKey: vapidPrivateKey,
 		VAPIDPublicKey:  vapidPublicKey,
-		Urgency:         webpush.UrgencyNormal,
+		Urgency:         urgency,
 		Subscriber:      os.Getenv("DOMAIN"),
 		SubIsURL:        true,
 	})
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+)
+
+const (
+	// How long before an activity starts to remind members.
+	activityReminderLead = time.Hour
+	// How long before a task is due to remind its assignee.
+	taskReminderLead = 24 * time.Hour
+)
+
+// Notification that a reminder should be received.
+type ReminderReceived struct {
+	Reminder ReminderReceivedReminder `json:"reminder"`
+}
+
+// The reminder that should be received.
+type ReminderReceivedReminder struct {
+	GroupID   GroupID `json:"groupId"`
+	Timestamp uint64  `json:"timestamp"`
+	Content   string  `json:"content"`
+}
+
+// A reminder that is due to be sent to a user.
+type reminder struct {
+	UserID    UserID
+	Timestamp uint64
+	Content   string
+}
+
+// Sends reminders for a group's upcoming activities and tasks that have not
+// already been sent. If `userID` is not nil, only that member is reminded.
+//
+// Reminders are marked as sent in the same transaction that finds them, so a
+// repeated activation never sends the same reminder twice. The group is only
+// updated if `group` (as already read) has reminders due.
+func remindGroup(group *Group, userID *UserID, now time.Time, database Database, notification Notification) error {
+	pending := *group
+	if len(pending.takeReminders(userID, now)) == 0 {
+		return nil
+	}
+	var reminders []reminder
+	var g *Group
+	err := database.UpdateGroup(group.GroupID, func(group *Group) error {
+		g = group
+		reminders = group.takeReminders(userID, now)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, reminder := range reminders {
+		notifyUser(reminder.UserID, ReminderReceived{
+			Reminder: ReminderReceivedReminder{
+				GroupID:   g.GroupID,
+				Timestamp: reminder.Timestamp,
+				Content:   censor(reminder.Content),
+			},
+		}, database, notification)
+		pushUser(reminder.UserID, ReminderPushed{
+			Reminder: ReminderPushedReminder{
+				Group:     censor(g.Name),
+				Timestamp: reminder.Timestamp,
+				Content:   censor(reminder.Content),
+			},
+		}, database)
+	}
+	return nil
+}
+
+// Finds a group's reminders that are due by `now` and have not already been
+// sent, marking them as sent. If `userID` is not nil, only finds that member's.
+func (group *Group) takeReminders(userID *UserID, now time.Time) []reminder {
+	var reminders []reminder
+	group.Activities = slices.Clone(group.Activities)
+	for i := range group.Activities {
+		activity := &group.Activities[i]
+		start := parseDateTime(activity.Date, activity.Start)
+		if start == nil || now.Before(start.Add(-activityReminderLead)) || !now.Before(*start) {
+			continue
+		}
+		for _, member := range group.Members {
+			if (userID != nil && member != *userID) || slices.Contains(activity.Reminded, member) {
+				continue
+			}
+			activity.Reminded = append(slices.Clip(activity.Reminded), member)
+			reminders = append(reminders, reminder{
+				UserID:    member,
+				Timestamp: uint64(start.UnixMilli()),
+				Content:   fmt.Sprintf("%s starts at %s", activity.Title, activity.Start),
+			})
+		}
+	}
+	group.Tasks = slices.Clone(group.Tasks)
+	for i := range group.Tasks {
+		task := &group.Tasks[i]
+		if task.Completed || task.Reminded || (userID != nil && task.Assignee != *userID) || !group.IsMember(task.Assignee) {
+			continue
+		}
+		due := parseDateTime(task.Due, "00:00")
+		if due == nil || now.Before(due.Add(-taskReminderLead)) {
+			continue
+		}
+		task.Reminded = true
+		reminders = append(reminders, reminder{
+			UserID:    task.Assignee,
+			Timestamp: uint64(due.UnixMilli()),
+			Content:   fmt.Sprintf("%s is due on %s", task.Title, task.Due),
+		})
+	}
+	return reminders
+}
+
+// Schedules an activation of the group around when a reminder becomes due,
+// moving the existing job instead if there is one.
+//
+// Returns the ID of the pending job, or "" if none could be scheduled. Errors
+// are logged, as reminders are best-effort.
+func scheduleReminder(groupID GroupID, jobID JobID, date time.Time, scheduler Scheduler) JobID {
+	if now := time.Now(); date.Before(now) {
+		date = now
+	}
+	if jobID != "" {
+		if err := scheduler.Reschedule(jobID, date); err == nil {
+			return jobID
+		}
+		// The job may have already run, so schedule a new one.
+	}
+	jobID, err := scheduler.Schedule(date, Activation{GroupID: &groupID})
+	if err != nil {
+		log.Printf("could not schedule reminder for group %d: %v\n", groupID, err)
+		return ""
+	}
+	return jobID
+}
+
+// Cancels a pending reminder job, if there is one.
+func cancelReminder(jobID JobID, scheduler Scheduler) {
+	if jobID == "" {
+		return
+	}
+	if err := scheduler.Cancel(jobID); err != nil {
+		log.Printf("could not cancel reminder job %s: %v\n", jobID, err)
+	}
+}
+
+// Schedules a reminder for an activity, unless it has already started, in
+// which case any pending reminder is canceled.
+//
+// Returns the ID of the pending job, or "" if there is none.
+func scheduleActivityReminder(groupID GroupID, activity Activity, scheduler Scheduler) JobID {
+	start := parseDateTime(activity.Date, activity.Start)
+	if start == nil || !time.Now().Before(*start) {
+		cancelReminder(activity.ReminderJob, scheduler)
+		return ""
+	}
+	return scheduleReminder(groupID, activity.ReminderJob, start.Add(-activityReminderLead), scheduler)
+}
+
+// Schedules a reminder for a task, unless it is complete or has no due date,
+// in which case any pending reminder is canceled.
+//
+// Returns the ID of the pending job, or "" if there is none.
+func scheduleTaskReminder(groupID GroupID, task Task, scheduler Scheduler) JobID {
+	due := parseDateTime(task.Due, "00:00")
+	if due == nil || task.Completed {
+		cancelReminder(task.ReminderJob, scheduler)
+		return ""
+	}
+	return scheduleReminder(groupID, task.ReminderJob, due.Add(-taskReminderLead), scheduler)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// Most days a group may keep chat messages for, other than forever.
+const groupMaxRetentionDays = 365
+
+// Helper to compute the expiry of a chat message sent at `timestamp`, under
+// the group's retention setting (see `Message.Expiry`).
+func (group *Group) MessageExpiry(timestamp UnixMillis) int64 {
+	if group.RetentionDays == 0 {
+		return 0
+	}
+	return time.UnixMilli(int64(timestamp)).Add(retentionPeriod(group.RetentionDays)).Unix()
+}
+
+func retentionPeriod(days uint64) time.Duration {
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Deletes a group's chat messages up to `end` (inclusive), unpinning them and
+// notifying members if there were any.
+func clearChat(group *Group, end MessageID, database Database, notification Notification) error {
+	deleted, err := database.DeleteMessages(group.GroupID, end)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(group.Pins, func(pin Pin) bool { return pin.MessageID <= end }) {
+		if err := database.UpdateGroup(group.GroupID, func(group *Group) error {
+			group.Pins = slices.DeleteFunc(slices.Clone(group.Pins), func(pin Pin) bool { return pin.MessageID <= end })
+			return nil
+		}); err != nil {
+			return err
+		}
+		notifyGroup(group, nil, database, notification)
+	}
+	if deleted {
+		notifyGroup(group, ChatCleared{Cleared: ChatClearedChat{
+			GroupID:   group.GroupID,
+			MessageID: end,
+		}}, database, notification)
+	}
+	return nil
+}
+
+// Deletes a group's chat messages that are older than its retention setting
+// allows, in case they weren't given an expiry (see `Message.Expiry`).
+func enforceRetention(group *Group, now time.Time, database Database, notification Notification) error {
+	if group.RetentionDays == 0 {
+		return nil
+	}
+	cutoff := now.Add(-retentionPeriod(group.RetentionDays))
+	return clearChat(group, lastMessageID(UnixMillis(cutoff.UnixMilli())), database, notification)
+}
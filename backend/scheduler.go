@@ -1,71 +1,283 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/scheduler"
 )
 
+type JobID = string
+
+// A service capable of activating the backend at a future time.
 type Scheduler interface {
-	Schedule(date time.Time, activation Activation) error
+	// Schedules an activation for the specified date.
+	//
+	// Returns the ID of the new job, or an error if the operation
+	// could not be completed.
+	Schedule(date time.Time, activation Activation) (JobID, error)
+	// Cancels a job, if it has not already run.
+	//
+	// Returns an error if the operation could not be completed.
+	Cancel(JobID) error
+	// Changes the date of a job that has not already run.
+	//
+	// Returns an error if no such job is pending, or if the operation
+	// could not be completed.
+	Reschedule(JobID, time.Time) error
+	// Lists jobs that have not already run.
+	//
+	// Returns an error if the operation could not be completed.
+	List() ([]Job, error)
 }
 
 // A wake-up scheduled by the scheduler, signifying that a
 // particular user and/or group may be of interest at a
 // future time (e.g. to send a notification to group members).
+// If neither is set, every group is of interest (see the "activate" cron job).
 type Activation struct {
 	UserID  *UserID
 	GroupID *GroupID
+	// The job that produced the activation, if any.
+	JobID JobID `json:",omitempty" dynamo:",omitempty"`
 }
 
+// How long to remember jobs that never completed.
+const jobRetention = 24 * time.Hour
+
+// Constructs the metadata of a job that will be persisted.
+func newJob(jobID JobID, date time.Time, activation Activation) Job {
+	activation.JobID = jobID
+	return Job{
+		JobID:      jobID,
+		Date:       uint64(date.UnixMilli()),
+		Activation: activation,
+		Expiry:     date.Add(jobRetention).Unix(),
+	}
+}
+
+// An AWS service to invoke the lambda function at a future time.
 type EventBridgeScheduler struct {
-	client *scheduler.Scheduler
+	client   *scheduler.Scheduler
+	database Database
 }
 
-func NewEventBridgeScheduler(sess *session.Session) *EventBridgeScheduler {
+const scheduleGroupName = "lemmeknow-backend"
+
+func NewEventBridgeScheduler(sess *session.Session, database Database) *EventBridgeScheduler {
 	return &EventBridgeScheduler{
-		client: scheduler.New(sess),
+		client:   scheduler.New(sess),
+		database: database,
 	}
 }
 
-func (eventBridgeScheduler *EventBridgeScheduler) Schedule(date time.Time, activation Activation) error {
-	name := aws.String(fmt.Sprintf("lemmeknow-event-%d", GenerateID()))
-	schedule := fmt.Sprintf("at(%s)", date.Format("2006-01-02T15:04:05"))
+func (eventBridgeScheduler *EventBridgeScheduler) Schedule(date time.Time, activation Activation) (JobID, error) {
+	job := newJob(fmt.Sprintf("lemmeknow-event-%d", GenerateID()), date, activation)
 	_, err := eventBridgeScheduler.client.CreateSchedule(&scheduler.CreateScheduleInput{
 		Description:           nil,
 		ActionAfterCompletion: aws.String(scheduler.ActionAfterCompletionDelete),
 		FlexibleTimeWindow: &scheduler.FlexibleTimeWindow{
 			Mode: aws.String(scheduler.FlexibleTimeWindowModeOff),
 		},
-		Name:               name,
-		ScheduleExpression: aws.String(schedule),
+		Name:               aws.String(job.JobID),
+		ScheduleExpression: aws.String(scheduleExpression(date)),
 		State:              aws.String(scheduler.ScheduleStateEnabled),
-		GroupName:          aws.String("lemmeknow-backend"),
-		Target: &scheduler.Target{
-			Arn:         aws.String(os.Getenv("AWS_LAMBDA_ARN")),
-			RoleArn:     aws.String(os.Getenv("AWS_SCHEDULER_ROLE_ARN")),
-			Input:       aws.String(string(mustMarshal(activation))),
-			RetryPolicy: &scheduler.RetryPolicy{MaximumRetryAttempts: aws.Int64(3)},
+		GroupName:          aws.String(scheduleGroupName),
+		Target:             scheduleTarget(job.Activation),
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := eventBridgeScheduler.database.WriteJob(job); err != nil {
+		// Don't leave a job that can't be referenced.
+		_ = eventBridgeScheduler.Cancel(job.JobID)
+		return "", err
+	}
+	return job.JobID, nil
+}
+
+func (eventBridgeScheduler *EventBridgeScheduler) Cancel(jobID JobID) error {
+	_, err := eventBridgeScheduler.client.DeleteSchedule(&scheduler.DeleteScheduleInput{
+		Name:      aws.String(jobID),
+		GroupName: aws.String(scheduleGroupName),
+	})
+	var awsErr awserr.Error
+	if err != nil && !(errors.As(err, &awsErr) && awsErr.Code() == scheduler.ErrCodeResourceNotFoundException) {
+		return err
+	}
+	return eventBridgeScheduler.database.DeleteJob(jobID)
+}
+
+func (eventBridgeScheduler *EventBridgeScheduler) Reschedule(jobID JobID, date time.Time) error {
+	job, err := eventBridgeScheduler.database.ReadJob(jobID)
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		return fmt.Errorf("no such job")
+	}
+	_, err = eventBridgeScheduler.client.UpdateSchedule(&scheduler.UpdateScheduleInput{
+		ActionAfterCompletion: aws.String(scheduler.ActionAfterCompletionDelete),
+		FlexibleTimeWindow: &scheduler.FlexibleTimeWindow{
+			Mode: aws.String(scheduler.FlexibleTimeWindowModeOff),
 		},
+		Name:               aws.String(jobID),
+		ScheduleExpression: aws.String(scheduleExpression(date)),
+		State:              aws.String(scheduler.ScheduleStateEnabled),
+		GroupName:          aws.String(scheduleGroupName),
+		Target:             scheduleTarget(job.Activation),
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	return eventBridgeScheduler.database.WriteJob(newJob(jobID, date, job.Activation))
+}
+
+func (eventBridgeScheduler *EventBridgeScheduler) List() ([]Job, error) {
+	return eventBridgeScheduler.database.ReadJobs()
+}
+
+// Formats a one-time EventBridge schedule expression.
+func scheduleExpression(date time.Time) string {
+	return fmt.Sprintf("at(%s)", date.UTC().Format("2006-01-02T15:04:05"))
+}
+
+// Targets this lambda function with an activation.
+func scheduleTarget(activation Activation) *scheduler.Target {
+	return &scheduler.Target{
+		Arn:         aws.String(os.Getenv("AWS_LAMBDA_ARN")),
+		RoleArn:     aws.String(os.Getenv("AWS_SCHEDULER_ROLE_ARN")),
+		Input:       aws.String(string(mustMarshal(activation))),
+		RetryPolicy: &scheduler.RetryPolicy{MaximumRetryAttempts: aws.Int64(3)},
+	}
+}
+
+// A source of time, which tests may replace to control the passage of time.
+type Clock interface {
+	Now() time.Time
+	// Calls f once d has elapsed.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// A pending call scheduled by a clock.
+type Timer interface {
+	// Prevents the call, returning false if it already happened or was stopped.
+	Stop() bool
+}
+
+// The clock of the operating system.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
 }
 
+// A local service that activates the backend from within the process.
+//
+// Jobs are persisted in the database, so that they survive a restart as long
+// as the database does.
 type LocalScheduler struct {
+	database     Database
+	notification Notification
+	clock        Clock
+	timers       map[JobID]Timer
+	mu           sync.Mutex
+}
+
+// Creates a local scheduler, resuming any jobs left in the database by a
+// previous instance. Jobs that became due in the meantime run immediately.
+func NewLocalScheduler(database Database, notification Notification, clock Clock) (*LocalScheduler, error) {
+	localScheduler := &LocalScheduler{
+		database:     database,
+		notification: notification,
+		clock:        clock,
+		timers:       make(map[JobID]Timer),
+	}
+	jobs, err := database.ReadJobs()
+	if err != nil {
+		return nil, err
+	}
+	localScheduler.mu.Lock()
+	defer localScheduler.mu.Unlock()
+	for _, job := range jobs {
+		localScheduler.start(job)
+	}
+	return localScheduler, nil
 }
 
-func NewLocalScheduler() *LocalScheduler {
-	return &LocalScheduler{}
+func (localScheduler *LocalScheduler) Schedule(date time.Time, activation Activation) (JobID, error) {
+	job := newJob(fmt.Sprintf("lemmeknow-event-%d", GenerateID()), date, activation)
+	if err := localScheduler.database.WriteJob(job); err != nil {
+		return "", err
+	}
+	localScheduler.mu.Lock()
+	defer localScheduler.mu.Unlock()
+	localScheduler.start(job)
+	return job.JobID, nil
 }
 
-func (localScheduler *LocalScheduler) Schedule(date time.Time, activation Activation) error {
-	go func() {
-		time.Sleep(time.Until(date))
-		Activate(activation)
-	}()
+func (localScheduler *LocalScheduler) Cancel(jobID JobID) error {
+	localScheduler.mu.Lock()
+	if timer, ok := localScheduler.timers[jobID]; ok {
+		timer.Stop()
+		delete(localScheduler.timers, jobID)
+	}
+	localScheduler.mu.Unlock()
+	return localScheduler.database.DeleteJob(jobID)
+}
+
+func (localScheduler *LocalScheduler) Reschedule(jobID JobID, date time.Time) error {
+	localScheduler.mu.Lock()
+	defer localScheduler.mu.Unlock()
+	timer, ok := localScheduler.timers[jobID]
+	if !ok {
+		return fmt.Errorf("no such job")
+	}
+	job, err := localScheduler.database.ReadJob(jobID)
+	if err != nil {
+		return err
+	}
+	if job == nil {
+		return fmt.Errorf("no such job")
+	}
+	*job = newJob(jobID, date, job.Activation)
+	if err := localScheduler.database.WriteJob(*job); err != nil {
+		return err
+	}
+	timer.Stop()
+	localScheduler.start(*job)
 	return nil
 }
+
+func (localScheduler *LocalScheduler) List() ([]Job, error) {
+	return localScheduler.database.ReadJobs()
+}
+
+// Starts a timer for the job. Caller must hold the lock.
+func (localScheduler *LocalScheduler) start(job Job) {
+	date := time.UnixMilli(int64(job.Date))
+	var timer Timer
+	timer = localScheduler.clock.AfterFunc(date.Sub(localScheduler.clock.Now()), func() {
+		localScheduler.mu.Lock()
+		// The job may have been canceled or rescheduled in the meantime.
+		current := localScheduler.timers[job.JobID] == timer
+		if current {
+			delete(localScheduler.timers, job.JobID)
+		}
+		localScheduler.mu.Unlock()
+		if current {
+			activateAt(job.Activation, localScheduler.clock.Now(), localScheduler.database, localScheduler.notification)
+		}
+	})
+	localScheduler.timers[job.JobID] = timer
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	sessionCookieName = "session"
+	// How long a session remains valid without being used.
+	sessionLifetime = 30 * 24 * time.Hour
+	// How long a session is used before it is replaced by a new one.
+	sessionRotationInterval = 24 * time.Hour
+)
+
+// Session-related API's.
+func RestSessionAPI(router *mux.Router, database Database) {
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodDelete:
+			session, err := checkSessionCookie(r, database)
+			if err != nil {
+				http.Error(w, "could not check cookie", http.StatusInternalServerError)
+				return
+			}
+			if session != nil {
+				if err := database.DeleteSession(session.SessionID); err != nil {
+					http.Error(w, "could not revoke session", http.StatusInternalServerError)
+					return
+				}
+			}
+			clearSessionCookie(w)
+			WriteJSON(w, nil)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// Creates a new session for the user and, if `w` is not nil, sets the
+// corresponding cookie.
+func createSession(w http.ResponseWriter, userID UserID, database Database) (*Session, error) {
+	sessionID, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := Session{
+		SessionID: sessionID,
+		UserID:    userID,
+		Created:   uint64(now.UnixMilli()),
+		Expiry:    now.Add(sessionLifetime).Unix(),
+	}
+	if err := database.CreateSession(session); err != nil {
+		return nil, err
+	}
+	if w != nil {
+		token, err := signSessionID(session.SessionID, database)
+		if err != nil {
+			return nil, err
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookieName,
+			Value:    token,
+			MaxAge:   int(sessionLifetime / time.Second),
+			Secure:   isOnLambda(),
+			SameSite: http.SameSiteStrictMode,
+			HttpOnly: true,
+			Path:     "/",
+		})
+	}
+	return &session, nil
+}
+
+// Instructs the client to forget its session cookie.
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		MaxAge:   -1,
+		Secure:   isOnLambda(),
+		SameSite: http.SameSiteStrictMode,
+		HttpOnly: true,
+		Path:     "/",
+	})
+}
+
+// Gets possibly-nil, unexpired session from a signed cookie. Only possible
+// error is database error.
+func checkSessionCookie(r *http.Request, database Database) (*Session, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil, nil
+	}
+	sessionID, ok, err := verifySessionToken(cookie.Value, database)
+	if err != nil || !ok {
+		return nil, err
+	}
+	session, err := database.ReadSession(sessionID)
+	if err != nil || session == nil {
+		return nil, err
+	}
+	if time.Now().Unix() >= session.Expiry {
+		// Expired sessions may linger until the database removes them.
+		_ = database.DeleteSession(session.SessionID)
+		return nil, nil
+	}
+	return session, nil
+}
+
+// Replaces a session with a new one if it has been in use for long enough.
+//
+// The old session is revoked, so a stolen cookie is only useful until
+// the next time the legitimate user rotates it.
+func maybeRotateSession(w http.ResponseWriter, session *Session, database Database) error {
+	created := time.UnixMilli(int64(session.Created))
+	if time.Since(created) < sessionRotationInterval {
+		return nil
+	}
+	if _, err := createSession(w, session.UserID, database); err != nil {
+		return err
+	}
+	return database.DeleteSession(session.SessionID)
+}
+
+// Produces a cookie value of the form "sessionID.signature".
+func signSessionID(sessionID SessionID, database Database) (string, error) {
+	key, err := getSessionKey(database)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(sessionID))
+	return sessionID + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Returns the session ID from a cookie value and whether its signature is valid.
+func verifySessionToken(token string, database Database) (SessionID, bool, error) {
+	sessionID, _, found := strings.Cut(token, ".")
+	if !found || sessionID == "" {
+		return "", false, nil
+	}
+	expected, err := signSessionID(sessionID, database)
+	if err != nil {
+		return "", false, err
+	}
+	return sessionID, hmac.Equal([]byte(token), []byte(expected)), nil
+}
+
+// Returns the key used to sign session cookies, generating and storing a new
+// one if needed.
+func getSessionKey(database Database) ([]byte, error) {
+	variableName := "SESSION_KEY"
+
+	encoded, err := database.ReadVariable(variableName)
+	if err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(encoded)
+	if err != nil || len(key) != sha256.Size {
+		key = make([]byte, sha256.Size)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		err = database.WriteVariable(variableName, hex.EncodeToString(key))
+		if err != nil {
+			// Key is not useful unless we have saved it for next time.
+			return nil, err
+		}
+	}
+	return key, nil
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
-	"strconv"
 
 	"github.com/gorilla/mux"
 )
@@ -15,12 +14,28 @@ type GetUserResponse struct {
 	Name   string    `json:"name"`
 	Status string    `json:"status"`
 	Groups []GroupID `json:"groups"`
+	// Only sent to the user themselves.
+	PushMentionsOnly bool                    `json:"pushMentionsOnly,omitempty"`
+	Unread           []GetUserResponseUnread `json:"unread,omitempty"`
+}
+
+// Unread chat messages in a group sent over JSON.
+type GetUserResponseUnread struct {
+	GroupID GroupID `json:"groupId"`
+	// Number of unread messages, up to a limit.
+	Count int `json:"count"`
+	// Whether there are more unread messages than counted.
+	More bool `json:"more"`
+	// Whether any of the counted messages mention the user.
+	Mentioned bool `json:"mentioned"`
 }
 
 // User edit sent over JSON.
 type PatchUserRequest struct {
 	Name   string `json:"name"`
 	Status string `json:"status"`
+	// Whether to only push chat messages that mention the user.
+	PushMentionsOnly *bool `json:"pushMentionsOnly"`
 }
 
 type SubjectUserKeyType struct{}
@@ -69,7 +84,7 @@ func RestSpecificUserAPI(router *mux.Router, database Database) {
 func RestUserAPI(router *mux.Router, database Database, notification Notification) {
 	RestSpecificUserAPI(AddHandler(router, "/{userID}"), database)
 	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		user, err := CheckCookie(r, database)
+		user, err := CheckCookie(w, r, database)
 		if err != nil {
 			http.Error(w, "could not check cookie", http.StatusInternalServerError)
 			return
@@ -84,21 +99,18 @@ func RestUserAPI(router *mux.Router, database Database, notification Notificatio
 					http.Error(w, "could not create user", http.StatusInternalServerError)
 					return
 				}
-				http.SetCookie(w, &http.Cookie{
-					Name:     "userID",
-					Value:    strconv.FormatUint(user.UserID, 10),
-					MaxAge:   365 * 24 * 3600,
-					Secure:   isOnLambda(),
-					SameSite: http.SameSiteStrictMode,
-					HttpOnly: true,
-					Path:     "/",
-				})
+				if _, err := createSession(w, user.UserID, database); err != nil {
+					http.Error(w, "could not create session", http.StatusInternalServerError)
+					return
+				}
 			}
 			WriteJSON(w, GetUserResponse{
-				UserID: user.UserID,
-				Name:   user.Name,
-				Groups: append([]GroupID{}, user.Groups...),
-				Status: user.Status,
+				UserID:           user.UserID,
+				Name:             user.Name,
+				Groups:           append([]GroupID{}, user.Groups...),
+				Status:           user.Status,
+				PushMentionsOnly: user.PushMentionsOnly,
+				Unread:           unreadCounts(user, database),
 			})
 		case http.MethodPatch:
 			var request PatchUserRequest
@@ -113,6 +125,9 @@ func RestUserAPI(router *mux.Router, database Database, notification Notificatio
 				if request.Status != "" {
 					user.Status = request.Status
 				}
+				if request.PushMentionsOnly != nil {
+					user.PushMentionsOnly = *request.PushMentionsOnly
+				}
 				return nil
 			}, database, notification); err != nil {
 				http.Error(w, "could not update user", http.StatusInternalServerError)
@@ -125,26 +140,34 @@ func RestUserAPI(router *mux.Router, database Database, notification Notificatio
 	})
 }
 
-// Gets possibly-nil user from cookie. Only possible error is database error.
-func CheckCookie(r *http.Request, database Database) (*User, error) {
-	cookie, err := r.Cookie("userID")
-	if err != nil {
-		return nil, nil
+// Gets possibly-nil user from signed session cookie. Only possible error is database error.
+//
+// If `w` is not nil, the session may be rotated, in which case a new cookie is set.
+func CheckCookie(w http.ResponseWriter, r *http.Request, database Database) (*User, error) {
+	session, err := checkSessionCookie(r, database)
+	if err != nil || session == nil {
+		return nil, err
 	}
-	userID, err := strconv.ParseUint(cookie.Value, 10, 64)
+	user, err := database.ReadUser(session.UserID)
 	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		// The user was deleted, so the session is useless.
+		_ = database.DeleteSession(session.SessionID)
 		return nil, nil
 	}
-	user, err := database.ReadUser(userID)
-	if err != nil {
-		return nil, err
+	if w != nil {
+		if err := maybeRotateSession(w, session, database); err != nil {
+			return nil, err
+		}
 	}
 	return user, nil
 }
 
 // If returns nil, an error has been sent and must return from handler.
 func Authenticate(w http.ResponseWriter, r *http.Request, database Database) *User {
-	user, err := CheckCookie(r, database)
+	user, err := CheckCookie(w, r, database)
 	if err != nil {
 		http.Error(w, "invalid cookie", http.StatusInternalServerError)
 		return nil
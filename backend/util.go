@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"os"
@@ -21,6 +23,15 @@ func unixMillis() uint64 {
 	return uint64(time.Now().UnixMilli())
 }
 
+// Generates a random, unguessable hexadecimal token.
+func generateToken() (string, error) {
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw[:]), nil
+}
+
 // marshal JSON, failing on any error.
 func mustMarshal(v any) json.RawMessage {
 	json, err := json.Marshal(v)
@@ -69,6 +80,17 @@ func parseTime(input string) *time.Time {
 	}
 }
 
+// Parses a date like "2006-01-02" and time like "15:04" in the service's
+// local time zone, returning nil in case of error.
+func parseDateTime(date string, clock string) *time.Time {
+	t, err := time.ParseInLocation(time.DateOnly+" 15:04", date+" "+clock, time.Local)
+	if err == nil {
+		return &t
+	} else {
+		return nil
+	}
+}
+
 // Returns the start and end dates (one mode) or whether using "dayOfWeek" mode.
 //
 // Returns nil, nil, false in case of any error.
@@ -127,6 +149,17 @@ func censor(input string) string {
 	return output
 }
 
+// Checks a chat retention setting (in days) for validity, if present.
+//
+// If returns true, error has been sent and should return.
+func invalidRetentionDays(w http.ResponseWriter, input *uint64) bool {
+	if input != nil && *input > groupMaxRetentionDays {
+		http.Error(w, "retention too long", http.StatusBadRequest)
+		return true
+	}
+	return false
+}
+
 // Checks if it is possible to append an item to a collection while remaining
 // within the specified limit.
 //
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
+	webpush "github.com/Appboy/webpush-go"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -30,3 +34,137 @@ func TestCalendarModeParsing(t *testing.T) {
 	assert.NotNil(t, end)
 	assert.False(t, dayOfWeek)
 }
+
+func TestSessionTokenSignature(t *testing.T) {
+	database := NewMemoryDatabase()
+	token, err := signSessionID("abcd", database)
+	assert.Nil(t, err)
+	sessionID, ok, err := verifySessionToken(token, database)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "abcd", sessionID)
+	_, ok, err = verifySessionToken("abce"+token[4:], database)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, ok, err = verifySessionToken("abcd", database)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestMentionParsing(t *testing.T) {
+	members := []User{{UserID: 1, Name: "Alex"}, {UserID: 2, Name: "Alex Smith"}, {UserID: 3, Name: "Bob"}, {UserID: 4}}
+	assert.Empty(t, parseMentions("hi Alex, email alex@bob.com", members))
+	assert.Empty(t, parseMentions("@Alexander @ @", members))
+	assert.Equal(t, []UserID{1, 3}, parseMentions("@alex and @BOB: @Alex!", members))
+	assert.Equal(t, []UserID{2, 1}, parseMentions("@Alex Smith, not @Alex Jones", members))
+}
+
+func TestChatMessagePush(t *testing.T) {
+	group := &Group{Name: "Hikers"}
+	message := Message{Timestamp: 1000, Sender: 1, Content: "@Robin ready?", Mentions: []UserID{2}}
+
+	// The service worker shows `mention` pushes, so check the JSON it receives.
+	mentioned := chatMessagePush(group, &User{UserID: 2, PushMentionsOnly: true}, message, "Alex", nil)
+	data, err := json.Marshal(mentioned)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"mention":{"group":"Hikers","timestamp":1000,"sender":"Alex","content":"@Robin ready?"}}`, string(data))
+	assert.Equal(t, webpush.UrgencyHigh, mentioned.(urgentPush).urgency())
+
+	assert.Equal(t, MessagePushed{Message: MessagePushedMessage{Group: "Hikers", Timestamp: 1000, Sender: "Alex", Content: "@Robin ready?"}},
+		chatMessagePush(group, &User{UserID: 3}, message, "Alex", nil))
+	assert.Nil(t, chatMessagePush(group, &User{UserID: 3, PushMentionsOnly: true}, message, "Alex", nil))
+}
+
+func TestCommandFields(t *testing.T) {
+	fields, ok := commandFields(` "Dinner?"  pizza “taco truck” "" `)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"Dinner?", "pizza", "taco truck", ""}, fields)
+	_, ok = commandFields(`"Dinner? pizza`)
+	assert.False(t, ok)
+}
+
+func TestAssigneeParsing(t *testing.T) {
+	members := []User{{UserID: 1, Name: "Alex Smith"}, {UserID: 2, Name: "Bob"}}
+	title, assignee, ok := cutAssignee("buy snacks @alex smith", members)
+	assert.True(t, ok)
+	assert.Equal(t, "buy snacks", title)
+	assert.Equal(t, UserID(1), *assignee)
+	title, assignee, ok = cutAssignee("email bob@example.com", members)
+	assert.True(t, ok)
+	assert.Equal(t, "email bob@example.com", title)
+	assert.Nil(t, assignee)
+	_, _, ok = cutAssignee("buy snacks @carol", members)
+	assert.False(t, ok)
+}
+
+func TestPollMigration(t *testing.T) {
+	group := Group{Poll: &Poll{Title: "who?"}, Polls: []Poll{{PollID: 1, Title: "where?"}}}
+	assert.True(t, group.migratePoll())
+	assert.Nil(t, group.Poll)
+	assert.Len(t, group.Polls, 2)
+	assert.Equal(t, "who?", group.Polls[1].Title)
+	assert.Equal(t, legacyPollID, group.Polls[1].PollID)
+	assert.False(t, group.migratePoll())
+}
+
+func TestLegacyGroupOwner(t *testing.T) {
+	group := Group{Members: []UserID{1, 2, 3}}
+	group.ensureOwner()
+	assert.Equal(t, UserID(1), group.Owner)
+	assert.Equal(t, RoleMember, group.RoleOf(2))
+
+	group = Group{Members: []UserID{1, 2, 3}, Admins: []UserID{4, 3}}
+	group.ensureOwner()
+	assert.Equal(t, UserID(3), group.Owner)
+	assert.Empty(t, group.Admins)
+	assert.False(t, group.IsAdmin(1))
+}
+
+func TestSearchTerms(t *testing.T) {
+	assert.Empty(t, searchTerms(" ?! "))
+	assert.Equal(t, []string{"dinner", "s", "at", "7", "café"}, searchTerms("Dinner's at 7, at CAFÉ"))
+}
+
+func TestChatSnippet(t *testing.T) {
+	assert.Equal(t, []GetChatSearchResponseSnippet{{Text: "the "}, {Text: "Beach", Match: true}, {Text: "! beaches, "}, {Text: "beach", Match: true}},
+		chatSnippet("the Beach! beaches, beach", []string{"beach"}))
+	assert.Equal(t, []GetChatSearchResponseSnippet{{Text: "no match"}}, chatSnippet("no match", []string{"beach"}))
+
+	long := strings.Repeat("a ", 100) + "beach" + strings.Repeat(" b", 100)
+	snippet := chatSnippet(long, []string{"beach"})
+	assert.Equal(t, "…", snippet[0].Text)
+	assert.Len(t, []rune(snippet[1].Text), chatSnippetLead)
+	assert.Equal(t, GetChatSearchResponseSnippet{Text: "beach", Match: true}, snippet[2])
+	assert.Equal(t, "…", snippet[len(snippet)-1].Text)
+}
+
+func TestCronScheduleParsing(t *testing.T) {
+	for _, expression := range []string{"sus", "0 * * *", "60 * * * ?", "0 * 1 * 2", "*/0 * * * ?", "5-1 * * * ?"} {
+		_, err := parseCronSchedule(expression)
+		assert.NotNil(t, err, expression)
+	}
+	for _, job := range cronJobs {
+		_, err := parseCronSchedule(job.Schedule)
+		assert.Nil(t, err, job.Name)
+	}
+
+	after := time.Date(2024, 2, 15, 19, 10, 30, 0, time.UTC) // a Thursday
+	for expression, next := range map[string]time.Time{
+		"0 * * * ?":      time.Date(2024, 2, 15, 20, 0, 0, 0, time.UTC),
+		"*/15 * * * ?":   time.Date(2024, 2, 15, 19, 15, 0, 0, time.UTC),
+		"30 3 * * ?":     time.Date(2024, 2, 16, 3, 30, 0, 0, time.UTC),
+		"0 12 ? * 2-6":   time.Date(2024, 2, 16, 12, 0, 0, 0, time.UTC),
+		"0 12 ? * 1,7":   time.Date(2024, 2, 17, 12, 0, 0, 0, time.UTC),
+		"0 0 29 2 ?":     time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		"0 0 1 1-12/6 ?": time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+		"0 0 30 2 ?":     {},
+	} {
+		schedule, err := parseCronSchedule(expression)
+		assert.Nil(t, err, expression)
+		assert.Equal(t, next, schedule.Next(after), expression)
+		if !next.IsZero() {
+			assert.True(t, schedule.Matches(next), expression)
+			assert.False(t, schedule.Matches(after), expression)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// How long a typing indicator lasts, unless the client sends another.
+const typingTTL = 5 * time.Second
+
+// Errors in inbound WebSocket messages that are the client's fault (see
+// `isWebSocketClientError`).
+var (
+	// The action isn't recognized.
+	errUnknownAction = errors.New("unknown action")
+	// The message isn't valid JSON, or has fields of the wrong type.
+	errUndecodableMessage = errors.New("could not decode message")
+	// The message is about a group the user isn't in.
+	errNotGroupMember = errors.New("not a member of group")
+)
+
+// Message sent by a client over WebSocket. The action determines the rest of
+// the fields.
+//
+// On AWS, API Gateway also selects a route by action, so all actions must go to
+// the "$default" route (see terraform/apigateway_ws.tf).
+type WebSocketRequest struct {
+	Action string `json:"action"`
+}
+
+// The client's user is typing in a group's chat.
+type TypingRequest struct {
+	GroupID GroupID `json:"groupId"`
+}
+
+// Notification that another member is typing in a group's chat.
+type MemberTyping struct {
+	Typing MemberTypingTyping `json:"typing"`
+}
+
+// The member that is typing, until a Unix millisecond time.
+type MemberTypingTyping struct {
+	GroupID GroupID `json:"groupId"`
+	UserID  UserID  `json:"userId"`
+	Expiry  uint64  `json:"expiry"`
+}
+
+// Response to a heartbeat from the client.
+type HeartbeatAcknowledged struct {
+	Pong HeartbeatAcknowledgedPong `json:"pong"`
+}
+
+type HeartbeatAcknowledgedPong struct {
+	Timestamp uint64 `json:"timestamp"`
+}
+
+// Inbound WebSocket message handler, for both local and AWS connections.
+//
+// Returns an error if the message is invalid (see `isWebSocketClientError`) or
+// could not be handled.
+func WebSocketMessage(database Database, notification Notification, connectionID ConnectionID, body []byte) error {
+	var request WebSocketRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return fmt.Errorf("%w: %w", errUndecodableMessage, err)
+	}
+
+	switch request.Action {
+	case "ping":
+		// Best-effort, like any notification.
+		_ = notification.Notify(connectionID, HeartbeatAcknowledged{
+			Pong: HeartbeatAcknowledgedPong{Timestamp: unixMillis()},
+		})
+		return nil
+	case "typing":
+		var typing TypingRequest
+		if err := json.Unmarshal(body, &typing); err != nil {
+			return fmt.Errorf("%w: %w", errUndecodableMessage, err)
+		}
+		userID, err := database.ReadConnection(connectionID)
+		if err != nil {
+			return err
+		}
+		if userID == nil {
+			return fmt.Errorf("unknown connection")
+		}
+		group, err := database.ReadGroup(typing.GroupID)
+		if err != nil {
+			return err
+		}
+		if group == nil || group.Archived != 0 || !group.IsMember(*userID) {
+			return errNotGroupMember
+		}
+		// The typist doesn't need to know.
+		others := *group
+		others.Members = slices.DeleteFunc(slices.Clone(group.Members), func(member UserID) bool { return member == *userID })
+		notifyGroup(&others, MemberTyping{
+			Typing: MemberTypingTyping{
+				GroupID: group.GroupID,
+				UserID:  *userID,
+				Expiry:  uint64(time.Now().Add(typingTTL).UnixMilli()),
+			},
+		}, database, notification)
+		return nil
+	default:
+		return errUnknownAction
+	}
+}
+
+// Helper to check if an error from `WebSocketMessage` is the client's fault,
+// so isn't worth retrying, as opposed to a failure to handle the message.
+func isWebSocketClientError(err error) bool {
+	return errors.Is(err, errUnknownAction) || errors.Is(err, errUndecodableMessage) || errors.Is(err, errNotGroupMember)
+}
@@ -19,6 +19,14 @@ export const users = writable({});
 
 export const userId = writable(null);
 
+// Mapping of group ID to {count, more, mentioned}, describing unread chat messages in each of the user's groups.
+//
+// This is set when the user is downloaded, and cleared for a group when it is read (perhaps in another tab).
+export const unread = writable({});
+
+// Mapping of group ID to a mapping of user ID to the Unix millisecond time until which that member is typing.
+export const typing = writable({});
+
 // @ts-nocheck
 // If `userId` is undefined, gets the currently-logged-in user.
 /**
@@ -176,10 +184,57 @@ async function getGroup(groupId) {
 	}
 }
 
+const DAY = 24 * 60 * 60 * 1000;
+// How long invite links last.
+const INVITE_LIFETIME = 7 * DAY;
+
+// Returns the token of an invite link, reusing one that lasts at least another
+// day if possible, since groups have a limited number of invites. Returns null
+// on failure.
+async function createInvite(groupId) {
+	try {
+		const url = `//${location.host}/api/group/${groupId}/invite/`;
+		const listResponse = await fetch(url);
+		if (listResponse.ok) {
+			const invites = await listResponse.json();
+			const reusable = invites.find(
+				(invite) => invite.maxUses == 0 && (invite.expiry == 0 || invite.expiry - Date.now() > DAY)
+			);
+			if (reusable) {
+				return reusable.token;
+			}
+		}
+		const response = await fetch(url, {
+			method: 'PATCH',
+			body: JSON.stringify({ expiry: Date.now() + INVITE_LIFETIME })
+		});
+		if (!response.ok) {
+			console.error('Failed to create invite:', await response.text());
+			return null;
+		}
+		const result = await response.json();
+		return result.token;
+	} catch (e) {
+		return null;
+	}
+}
+
+async function joinGroup(groupId, token) {
+	try {
+		const response = await fetch(`//${location.host}/api/group/${groupId}/join/`, {
+			method: 'PATCH',
+			body: JSON.stringify({ token })
+		});
+		return response.ok;
+	} catch (e) {
+		return false;
+	}
+}
+
 async function createPoll(groupId, title, options) {
 	try {
 		const response = await fetch(`//${location.host}/api/group/${groupId}/poll/`, {
-			method: 'PUT',
+			method: 'PATCH',
 			headers: {
 				'Content-Type': 'application/json'
 			},
@@ -187,17 +242,17 @@ async function createPoll(groupId, title, options) {
 		});
 		if (response.status === 200) {
 			console.log('success for creating poll');
-			const group = await getGroup(groupId);
-			console.log('poll as: ', group.poll);
+			const result = await response.json();
+			return result.pollId;
 		}
 	} catch (e) {
 		return null;
 	}
 }
 
-async function updateVotes(groupID, votes) {
+async function updateVotes(groupID, pollID, votes) {
 	try {
-		const response = await fetch(`//${location.host}/api/group/${groupID}/poll/`, {
+		const response = await fetch(`//${location.host}/api/group/${groupID}/poll/${pollID}/`, {
 			method: 'PATCH',
 			body: JSON.stringify({ votes })
 		});
@@ -209,9 +264,23 @@ async function updateVotes(groupID, votes) {
 	}
 }
 
-async function deletePoll(groupID) {
+async function closePoll(groupID, pollID) {
 	try {
-		const response = await fetch(`//${location.host}/api/group/${groupID}/poll/`, {
+		const response = await fetch(`//${location.host}/api/group/${groupID}/poll/${pollID}/`, {
+			method: 'PATCH',
+			body: JSON.stringify({ closed: true })
+		});
+		if (response.status === 200) {
+			console.log('success for closing poll');
+		}
+	} catch (e) {
+		return null;
+	}
+}
+
+async function deletePoll(groupID, pollID) {
+	try {
+		const response = await fetch(`//${location.host}/api/group/${groupID}/poll/${pollID}/`, {
 			method: 'DELETE'
 		});
 		if (response.status === 200) {
@@ -222,9 +291,25 @@ async function deletePoll(groupID) {
 	}
 }
 
-async function sendMessage(groupID, content) {
+/**
+ * @param {number} groupID
+ * @param {string} content
+ * @param {number | undefined} parentId the message to reply to, if any
+ */
+async function sendMessage(groupID, content, parentId = undefined) {
 	try {
 		return await fetch(`//${location.host}/api/group/${groupID}/chat/`, {
+			method: 'PATCH',
+			body: JSON.stringify({ content, parentId })
+		});
+	} catch (e) {
+		return null;
+	}
+}
+
+async function editMessage(groupID, messageID, content) {
+	try {
+		return await fetch(`//${location.host}/api/group/${groupID}/chat/${messageID}/`, {
 			method: 'PATCH',
 			body: JSON.stringify({ content })
 		});
@@ -233,6 +318,106 @@ async function sendMessage(groupID, content) {
 	}
 }
 
+async function deleteMessage(groupID, messageID) {
+	try {
+		return await fetch(`//${location.host}/api/group/${groupID}/chat/${messageID}/`, {
+			method: 'DELETE'
+		});
+	} catch (e) {
+		return null;
+	}
+}
+
+async function setReaction(groupID, messageID, emoji, react) {
+	try {
+		return await fetch(
+			`//${location.host}/api/group/${groupID}/chat/${messageID}/reaction/${encodeURIComponent(emoji)}/`,
+			{
+				method: react ? 'PUT' : 'DELETE'
+			}
+		);
+	} catch (e) {
+		return null;
+	}
+}
+
+async function setPinned(groupID, messageID, pinned) {
+	try {
+		return await fetch(`//${location.host}/api/group/${groupID}/chat/${messageID}/pin/`, {
+			method: pinned ? 'PUT' : 'DELETE'
+		});
+	} catch (e) {
+		return null;
+	}
+}
+
+/**
+ * Announces something to all members of a group (admins only).
+ * @param {number} groupId
+ * @param {string} content
+ * @param {number | undefined} expiry Unix millisecond time after which the announcement is removed
+ */
+async function createAnnouncement(groupId, content, expiry = undefined) {
+	try {
+		return await fetch(`//${location.host}/api/group/${groupId}/announcement/`, {
+			method: 'PATCH',
+			headers: {
+				'Content-Type': 'application/json'
+			},
+			body: JSON.stringify({ content, expiry })
+		});
+	} catch (e) {
+		console.error('Error creating announcement:', e);
+		return null;
+	}
+}
+
+async function deleteAnnouncement(groupId, announcementId) {
+	try {
+		return await fetch(`//${location.host}/api/group/${groupId}/announcement/${announcementId}/`, {
+			method: 'DELETE'
+		});
+	} catch (e) {
+		console.error('Error deleting announcement:', e);
+		return null;
+	}
+}
+
+/**
+ * @param {number} groupId
+ * @param {number} retentionDays days to keep chat messages, or 0 to keep them forever
+ */
+async function setRetention(groupId, retentionDays) {
+	try {
+		return await fetch(`//${location.host}/api/group/${groupId}/`, {
+			method: 'PATCH',
+			headers: {
+				'Content-Type': 'application/json'
+			},
+			body: JSON.stringify({ retentionDays })
+		});
+	} catch (e) {
+		console.error('Error setting retention:', e);
+		return null;
+	}
+}
+
+/**
+ * @param {number} groupId
+ * @param {number | undefined} end Unix millisecond time of the latest message to purge, or all
+ */
+async function purgeMessages(groupId, end = undefined) {
+	try {
+		const query = end === undefined ? '' : `?end=${end}`;
+		return await fetch(`//${location.host}/api/group/${groupId}/chat/${query}`, {
+			method: 'DELETE'
+		});
+	} catch (e) {
+		console.error('Error purging messages:', e);
+		return null;
+	}
+}
+
 async function updateUserName(userId, newName) {
 	try {
 		const response = await fetch(`//${location.host}/api/user/`, {
@@ -290,21 +475,27 @@ async function updateStatus(status) {
  * @param {any[]} messages
  */
 function sortMessages(messages) {
-	messages.sort((a, b) => a.timestamp - b.timestamp);
+	messages.sort((a, b) => a.messageId - b.messageId);
 }
 
-async function fetchMessages(groupId, start, end) {
+/**
+ * @param {number} groupId
+ * @param {number} start
+ * @param {number} end
+ * @param {string | undefined} cursor continues from a previous page, if present
+ */
+async function fetchMessages(groupId, start, end, cursor = undefined) {
 	try {
+		const params = cursor ? { cursor } : { start, end };
 		const response = await fetch(
-			`//${location.host}/api/group/${groupId}/chat/?` + new URLSearchParams({ start, end }),
+			`//${location.host}/api/group/${groupId}/chat/?` + new URLSearchParams(params),
 			{
 				method: 'GET'
 			}
 		);
 		const result = await response.json();
-		if (result.continue == true) {
-			const newEnd = result.messages[0].timestamp - 1;
-			fetchMessages(groupId, start, newEnd);
+		if (result.continue) {
+			fetchMessages(groupId, start, end, result.continue);
 		}
 		console.log(result);
 		groups.update((existing) => {
@@ -321,10 +512,95 @@ async function fetchMessages(groupId, start, end) {
 	}
 }
 
+/**
+ * @param {number} groupId
+ * @param {number} parentId
+ * @param {string | undefined} cursor continues from a previous page, if present
+ * @returns the page of replies, or null on failure
+ */
+async function fetchReplies(groupId, parentId, cursor = undefined) {
+	try {
+		const params = cursor ? { cursor } : {};
+		const response = await fetch(
+			`//${location.host}/api/group/${groupId}/chat/${parentId}/thread/?` +
+				new URLSearchParams(params),
+			{
+				method: 'GET'
+			}
+		);
+		return await response.json();
+	} catch (e) {
+		console.log(e);
+		return null;
+	}
+}
+
+/**
+ * Searches a group's chat, latest matches first.
+ * @param {number} groupId
+ * @param {string} query words that must all appear in a message
+ * @param {{sender?: number, start?: number, end?: number, cursor?: string}} options
+ * @returns the page of results, each with a `snippet` to highlight, or null on failure
+ */
+async function searchMessages(groupId, query, options = {}) {
+	try {
+		const params = { q: query };
+		for (const [key, value] of Object.entries(options)) {
+			if (value !== undefined) {
+				params[key] = value;
+			}
+		}
+		const response = await fetch(
+			`//${location.host}/api/group/${groupId}/chat/search/?` + new URLSearchParams(params),
+			{
+				method: 'GET'
+			}
+		);
+		if (!response.ok) {
+			return null;
+		}
+		return await response.json();
+	} catch (e) {
+		console.log(e);
+		return null;
+	}
+}
+
+/**
+ * Marks a group's chat as read up to a message, or the latest message if `messageId` is undefined.
+ * @param {number} groupId
+ * @param {number | undefined} messageId
+ */
+async function markRead(groupId, messageId = undefined) {
+	try {
+		return await fetch(`//${location.host}/api/group/${groupId}/chat/read/`, {
+			method: 'PATCH',
+			body: JSON.stringify({ messageId })
+		});
+	} catch (e) {
+		return null;
+	}
+}
+
 let webSocket;
+let lastTyping = 0;
+
+/**
+ * Tells other members the user is typing, at most every couple of seconds.
+ * @param {number} groupId
+ */
+function sendTyping(groupId) {
+	if (!webSocket || webSocket.readyState !== WebSocket.OPEN || Date.now() - lastTyping < 2000) {
+		return;
+	}
+	lastTyping = Date.now();
+	webSocket.send(JSON.stringify({ action: 'typing', groupId }));
+}
+
 async function openWebSocket() {
 	const webSocketProtocol = location.protocol == 'http:' ? 'ws:' : 'wss:';
 	webSocket = new WebSocket(`${webSocketProtocol}//${location.host}/ws/`);
+	const heartbeat = setInterval(() => webSocket.send(JSON.stringify({ action: 'ping' })), 30000);
 	webSocket.onopen = console.log;
 	webSocket.onmessage = (event) => {
 		console.log(event);
@@ -338,7 +614,18 @@ async function openWebSocket() {
 				return { ...existing, [message.user.userId]: { ...message.user, userId: undefined } };
 			});
 		}
-		if (message.message) {
+		if (message.message && message.message.parentId) {
+			groups.update((existing) => {
+				const parent = existing[message.message.groupId]?.messages.find(
+					(m) => m.messageId === message.message.parentId
+				);
+				if (parent) {
+					parent.replyCount = (parent.replyCount || 0) + 1;
+					parent.lastReply = message.message.timestamp;
+				}
+				return existing;
+			});
+		} else if (message.message) {
 			groups.update((existing) => {
 				if (!(message.message.groupId in existing)) {
 					existing[message.message.groupId] = [];
@@ -348,15 +635,80 @@ async function openWebSocket() {
 				return existing;
 			});
 		}
+		if (message.typing) {
+			typing.update((existing) => {
+				existing[message.typing.groupId] = {
+					...existing[message.typing.groupId],
+					[message.typing.userId]: message.typing.expiry
+				};
+				return existing;
+			});
+		}
+		if (message.read) {
+			unread.update((existing) => {
+				delete existing[message.read.groupId];
+				return existing;
+			});
+		}
+		if (message.reaction) {
+			groups.update((existing) => {
+				const target = existing[message.reaction.groupId]?.messages.find(
+					(m) => m.messageId === message.reaction.messageId
+				);
+				if (target) {
+					target.reactions = { ...target.reactions, [message.reaction.emoji]: message.reaction.users };
+					if (message.reaction.users.length == 0) {
+						delete target.reactions[message.reaction.emoji];
+					}
+				}
+				return existing;
+			});
+		}
+		if (message.cleared) {
+			groups.update((existing) => {
+				const group = existing[message.cleared.groupId];
+				if (group?.messages) {
+					group.messages = group.messages.filter(
+						(m) => m.messageId > message.cleared.messageId
+					);
+				}
+				return existing;
+			});
+		}
+		if (message.messageEdited || message.messageDeleted) {
+			const changed = message.messageEdited || message.messageDeleted;
+			groups.update((existing) => {
+				const target = existing[changed.groupId]?.messages.find(
+					(m) => m.messageId === changed.messageId
+				);
+				if (target) {
+					if (message.messageEdited) {
+						target.content = changed.content;
+						target.editedAt = changed.editedAt;
+					} else {
+						target.content = '';
+						target.deleted = true;
+						target.reactions = undefined;
+					}
+				}
+				return existing;
+			});
+		}
 	};
 	webSocket.onerror = console.log;
-	webSocket.onclose = console.log;
+	webSocket.onclose = (event) => {
+		clearInterval(heartbeat);
+		console.log(event);
+	};
 }
 
 if (browser) {
 	getUser().then((user) => {
 		console.log(user);
 		userId.set(user.userId);
+		unread.set(
+			Object.fromEntries((user.unread || []).map(({ groupId, ...rest }) => [groupId, rest]))
+		);
 		users.update((allUsers) => {
 			allUsers[user.userId] = user;
 			return allUsers;
@@ -368,13 +720,28 @@ if (browser) {
 export {
 	getUser,
 	createGroup,
+	createInvite,
+	joinGroup,
 	createAvailability,
 	createTask,
 	createPoll,
 	updateVotes,
+	closePoll,
 	deletePoll,
 	sendMessage,
+	editMessage,
+	deleteMessage,
+	setReaction,
+	setPinned,
+	createAnnouncement,
+	deleteAnnouncement,
+	setRetention,
+	purgeMessages,
 	fetchMessages,
+	fetchReplies,
+	searchMessages,
+	markRead,
+	sendTyping,
 	getGroup,
 	deleteTask,
 	deleteAvailability,
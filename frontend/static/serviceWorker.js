@@ -1,7 +1,23 @@
 self.addEventListener('push', function (event) {
 	var data = event.data.json();
 
-	if (data.message) {
+	if (data.announcement) {
+		event.waitUntil(
+			self.registration.showNotification(`${data.announcement.sender} in ${data.announcement.group}`, {
+				body: data.announcement.content,
+				timestamp: data.announcement.timestamp,
+				requireInteraction: true
+			})
+		);
+	} else if (data.mention) {
+		event.waitUntil(
+			self.registration.showNotification(`${data.mention.sender} mentioned you in ${data.mention.group}`, {
+				body: data.mention.content,
+				timestamp: data.mention.timestamp,
+				requireInteraction: true
+			})
+		);
+	} else if (data.message) {
 		// https://developer.mozilla.org/en-US/docs/Web/API/ServiceWorkerRegistration/showNotification
 		event.waitUntil(
 			self.registration.showNotification(`${data.message.sender} in ${data.message.group}`, {
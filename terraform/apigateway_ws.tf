@@ -56,6 +56,7 @@ resource "aws_apigatewayv2_integration_response" "backend" {
   integration_response_key = "/200/"
 }
 
+# Messages from clients, routed by action (see backend/websocket.go).
 resource "aws_apigatewayv2_route" "default" {
   api_id    = aws_apigatewayv2_api.backend.id
   route_key = "$default"
@@ -20,6 +20,8 @@ resource "aws_dynamodb_table" "user" {
   }
 }
 
+# Messages from before they had IDs, keyed by timestamp. Kept until copied to
+# the table below with `make migrate-messages` (see README.md).
 resource "aws_dynamodb_table" "message" {
   name         = "lemmeknow-messages"
   billing_mode = "PAY_PER_REQUEST"
@@ -37,6 +39,63 @@ resource "aws_dynamodb_table" "message" {
   }
 }
 
+resource "aws_dynamodb_table" "message_v2" {
+  name         = "lemmeknow-messages-v2"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "GroupID"
+  range_key    = "MessageID"
+
+  attribute {
+    name = "GroupID"
+    type = "N"
+  }
+
+  attribute {
+    name = "MessageID"
+    type = "N"
+  }
+
+  attribute {
+    name = "Thread"
+    type = "S"
+  }
+
+  # Must match messageThreadIndexName in backend/database.go.
+  global_secondary_index {
+    name            = "Thread-index"
+    hash_key        = "Thread"
+    range_key       = "MessageID"
+    projection_type = "ALL"
+  }
+
+  ttl {
+    attribute_name = "Expiry"
+    enabled        = true
+  }
+}
+
+resource "aws_dynamodb_table" "message_index" {
+  name         = "lemmeknow-message-index"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "Term"
+  range_key    = "MessageID"
+
+  attribute {
+    name = "Term"
+    type = "S"
+  }
+
+  attribute {
+    name = "MessageID"
+    type = "N"
+  }
+
+  ttl {
+    attribute_name = "Expiry"
+    enabled        = true
+  }
+}
+
 resource "aws_dynamodb_table" "connection" {
   name         = "lemmeknow-connections"
   billing_mode = "PAY_PER_REQUEST"
@@ -48,6 +107,38 @@ resource "aws_dynamodb_table" "connection" {
   }
 }
 
+resource "aws_dynamodb_table" "session" {
+  name         = "lemmeknow-sessions"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "SessionID"
+
+  attribute {
+    name = "SessionID"
+    type = "S"
+  }
+
+  ttl {
+    attribute_name = "Expiry"
+    enabled        = true
+  }
+}
+
+resource "aws_dynamodb_table" "job" {
+  name         = "lemmeknow-jobs"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "JobID"
+
+  attribute {
+    name = "JobID"
+    type = "S"
+  }
+
+  ttl {
+    attribute_name = "Expiry"
+    enabled        = true
+  }
+}
+
 resource "aws_dynamodb_table" "variable" {
   name         = "lemmeknow-variables"
   billing_mode = "PAY_PER_REQUEST"
@@ -1,13 +1,28 @@
+locals {
+  # Must match cronJobs in backend/cron.go.
+  cron_jobs = {
+    activate                  = "cron(0 * * * ? *)"
+    "delete-expired-messages" = "cron(30 * * * ? *)"
+  }
+}
+
 resource "aws_cloudwatch_event_rule" "backend_cron" {
-  name                = "lemmeknow-backend-cron"
-  description         = "Run lemmeknow-backend-api lambda every hour"
-  schedule_expression = "cron(0 * * * ? *)"
+  for_each            = local.cron_jobs
+  name                = "lemmeknow-backend-cron-${each.key}"
+  description         = "Run ${each.key} cron job of lemmeknow-backend-api lambda"
+  schedule_expression = each.value
 }
 
 resource "aws_cloudwatch_event_target" "backend_target" {
+  for_each  = local.cron_jobs
   arn       = aws_lambda_function.backend.arn
-  rule      = aws_cloudwatch_event_rule.backend_cron.name
+  rule      = aws_cloudwatch_event_rule.backend_cron[each.key].name
   target_id = "lemmeknow-backend"
+  # The detail type identifies the cron job to the lambda.
+  input = jsonencode({
+    detail-type = each.key
+    detail      = {}
+  })
 }
 
 resource "aws_lambda_permission" "backend_cron" {
@@ -57,6 +57,7 @@ data "aws_iam_policy_document" "backend_policy" {
   statement {
     sid = "dynamodb"
     actions = [
+      "dynamodb:BatchWriteItem",
       "dynamodb:DeleteItem",
       "dynamodb:GetItem",
       "dynamodb:PutItem",
@@ -68,7 +69,12 @@ data "aws_iam_policy_document" "backend_policy" {
       aws_dynamodb_table.user.arn,
       aws_dynamodb_table.group.arn,
       aws_dynamodb_table.message.arn,
+      aws_dynamodb_table.message_v2.arn,
+      "${aws_dynamodb_table.message_v2.arn}/index/*",
+      aws_dynamodb_table.message_index.arn,
       aws_dynamodb_table.connection.arn,
+      aws_dynamodb_table.session.arn,
+      aws_dynamodb_table.job.arn,
       aws_dynamodb_table.variable.arn
     ]
   }
@@ -84,7 +90,9 @@ data "aws_iam_policy_document" "backend_policy" {
   statement {
     sid = "scheduler"
     actions = [
-      "scheduler:CreateSchedule"
+      "scheduler:CreateSchedule",
+      "scheduler:DeleteSchedule",
+      "scheduler:UpdateSchedule"
     ]
     resources = ["*"]
   }